@@ -16,78 +16,295 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 
 	lib "github.com/barrett370/go-canvas-cUrl/lib"
 	"github.com/spf13/cobra"
 )
 
+var (
+	jobs        int
+	incremental bool
+	force       bool
+	layout      string
+	output      string
+	minSize     string
+	maxSize     string
+	since       string
+	until       string
+	mimeClass   string
+	noProgress  bool
+	pages       string
+	assignments string
+	discussions string
+)
+
 // downloadCmd represents the download command
 var downloadCmd = &cobra.Command{
 	Use:   "download mod1 mod2 ...| all",
 	Short: "downloads all or given modules",
 	Long: `This Command is used to download all files (not excluded by .scrapeignore) from all or specific modules. 
 	To download all modules, use 'download all' or 'download' to download specific modules use 'download <module1name> <module2name> ...'
-	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("download called")
+
+` + courseSelectorHelp,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
 		if len(args) > 0 {
 			if strings.ToLower(args[0]) == "all" {
 				args = make([]string, 0)
 			}
 		}
 
-		requester, err := lib.GetRequester()
+		ctx, cancel := runContext()
+		defer cancel()
+
+		requester, err := newRequester()
 		if err != nil {
-			panic(fmt.Errorf("Error getting requester: %s", err))
+			return fmt.Errorf("getting requester: %w", err)
 		}
 
-		courses, err := lib.GetCourses(requester, args)
+		courses, err := selectCourses(ctx, requester, args)
 		if err != nil {
-			panic(fmt.Errorf("Error getting courses %s", err))
+			return stopped(ctx, fmt.Errorf("getting courses: %w", err))
 		}
 
-		requester.Context = "/api/v1/courses/"
+		mode := lib.SyncMissing
+		switch {
+		case force:
+			mode = lib.SyncAll
+		case incremental:
+			mode = lib.SyncChanged
+		}
+		fileLayout, err := lib.ParseLayout(configString(requester, layout, "Layout"))
+		if err != nil {
+			return usageError(err)
+		}
+		pathTemplate, err := lib.NewPathTemplate(configString(requester, output, "Output"), fileLayout)
+		if err != nil {
+			return usageError(err)
+		}
+		filter, err := lib.ParseFilter(lib.FilterSpec{
+			MinSize:   configString(requester, minSize, "MinSize"),
+			MaxSize:   configString(requester, maxSize, "MaxSize"),
+			Since:     configString(requester, since, "Since"),
+			Until:     configString(requester, until, "Until"),
+			MimeClass: configString(requester, mimeClass, "MimeClass"),
+		})
+		if err != nil {
+			return usageError(err)
+		}
+		exportOpts := lib.ExportOptions{Filter: filter}
+		exportOpts.Pages, err = lib.ParseExportFormats(configString(requester, pages, "Pages"))
+		if err != nil {
+			return usageError(err)
+		}
+		exportOpts.Assignments, err = lib.ParseExportFormats(configString(requester, assignments, "Assignments"))
+		if err != nil {
+			return usageError(err)
+		}
+		exportOpts.Discussions, err = lib.ParseExportFormats(configString(requester, discussions, "Discussions"))
+		if err != nil {
+			return usageError(err)
+		}
+		var exporter *lib.Exporter
+		if len(exportOpts.Pages) > 0 || len(exportOpts.Assignments) > 0 || len(exportOpts.Discussions) > 0 {
+			exporter = lib.NewExporter(requester, pathTemplate, exportOpts)
+		}
+		manifest, err := lib.LoadManifest(pathTemplate.Root())
+		if err != nil {
+			return fmt.Errorf("reading manifest: %w", err)
+		}
+		// the progress display is only drawn for people watching a terminal, not for scripts or log tooling
+		var progress lib.Progress
+		var display *progressDisplay
+		if !noProgress && isTerminal(os.Stdout) && !strings.EqualFold(logFormat, string(lib.LogJSON)) {
+			display = newProgressDisplay(os.Stdout, os.Stderr)
+			logger.SetOutput(display)
+			progress = display
+		}
+		scheduler := lib.NewScheduler(ctx, requester, lib.SchedulerOptions{
+			Jobs:     jobs,
+			Mode:     mode,
+			Manifest: manifest,
+			Output:   pathTemplate,
+			Filter:   filter,
+			Progress: progress,
+		})
+		// errs collects the errors met while looking for files, they do not stop the run
+		var errs []error
+		// collect adds err to errs, unless the run was cancelled, which is reported once at the end
+		collect := func(err error) {
+			if ctx.Err() == nil {
+				errs = append(errs, err)
+			}
+		}
 		for _, course := range courses {
-			fmt.Println("Searching course: ", course.Name)
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Info("Searching course", "course", course.Name, "course_id", course.ID)
 
-			modules, err := course.GetModules(requester)
-			if err != nil {
-				switch e := err.(type) {
-				case *lib.NoModulesError:
-					err = course.GetFiles(requester)
-					if err != nil {
-						fmt.Printf(e.Error() + "\n")
-						continue
-					}
-				case *lib.NoFilesError:
-					continue
-				default:
-					fmt.Printf(e.Error() + "\n")
-					continue
+			modules, err := course.GetModules(ctx, requester)
+			var noModules *lib.NoModulesError
+			if isUnavailable(err) {
+				logger.Info("Course has no modules available, searching its files", "course", course.Name, "course_id", course.ID)
+			}
+			if errors.As(err, &noModules) || isUnavailable(err) {
+				err = course.GetFiles(ctx, requester, scheduler)
+				var noFiles *lib.NoFilesError
+				if errors.As(err, &noFiles) {
+					logger.Info("Course has no files available", "course", course.Name, "course_id", course.ID)
+				} else if err != nil {
+					collect(fmt.Errorf("%s: listing files: %w", course.Name, err))
 				}
+			} else if err != nil {
+				collect(fmt.Errorf("%s: listing modules: %w", course.Name, err))
 			}
 			for _, module := range modules {
-				folders, err := module.GetFolders(requester)
-
+				if ctx.Err() != nil {
+					break
+				}
+				folders, err := module.GetFolders(ctx, requester)
 				if err != nil {
-					fmt.Printf(err.Error() + "\n")
+					collect(fmt.Errorf("%s: module %s: %w", course.Name, module.Name, err))
 				}
 				for _, folder := range folders {
-					err = folder.GetFiles(requester, course)
+					if ctx.Err() != nil {
+						break
+					}
+					err = folder.GetFiles(ctx, requester, course, module, scheduler, exporter)
 					if err != nil {
-						fmt.Printf(err.Error() + "\n")
+						collect(fmt.Errorf("%s: module %s: %w", course.Name, module.Name, err))
 					}
 				}
 			}
+			if ctx.Err() != nil {
+				break
+			}
+			if len(exportOpts.Assignments) > 0 {
+				err = course.GetAssignments(ctx, requester, scheduler, exporter)
+				if isUnavailable(err) {
+					logger.Info("Course has no assignments available", "course", course.Name, "course_id", course.ID)
+				} else if err != nil {
+					collect(fmt.Errorf("%s: assignments: %w", course.Name, err))
+				}
+			}
+			if len(exportOpts.Discussions) > 0 {
+				err = course.GetDiscussions(ctx, requester, scheduler, exporter)
+				if isUnavailable(err) {
+					logger.Info("Course has no discussions available", "course", course.Name, "course_id", course.ID)
+				} else if err != nil {
+					collect(fmt.Errorf("%s: discussions: %w", course.Name, err))
+				}
+			}
+			err = exporter.AddPages(ctx, course)
+			if isUnavailable(err) {
+				logger.Info("Course has no pages available", "course", course.Name, "course_id", course.ID)
+			} else if err != nil {
+				collect(fmt.Errorf("%s: listing pages: %w", course.Name, err))
+			}
 		}
 
+		summary := scheduler.Wait()
+		if display != nil {
+			display.Close()
+			logger.SetOutput(os.Stderr)
+		}
+		logger.Info("Download finished", "downloaded", summary.Downloaded, "bytes", summary.Bytes, "skipped", summary.Skipped,
+			"excluded", summary.Excluded, "failed", summary.Failed, "cancelled", summary.Cancelled)
+		errs = append(errs, summary.Errors...)
+		// pages, assignments and discussions are written last so their links can point at the files just downloaded
+		if exporter != nil {
+			if err := exporter.Write(ctx, manifest); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		logErrorSummary(errs)
+		if err := ctx.Err(); err != nil {
+			return stopped(ctx, fmt.Errorf("%d downloads were cancelled, run again to resume: %w", summary.Cancelled, err))
+		}
+		for _, err := range errs {
+			if errors.Is(err, lib.ErrAuth) {
+				return fmt.Errorf("Canvas rejected the access token: %w", err)
+			}
+		}
+		if len(errs) > 0 {
+			return &exitError{code: exitPartial, err: fmt.Errorf("finished with %d errors", len(errs))}
+		}
+		return nil
 	},
 }
 
+// isUnavailable reports whether err means a course does not offer what was asked for, as Canvas
+// answers when a course's tab is disabled
+func isUnavailable(err error) bool {
+	return errors.Is(err, lib.ErrForbidden) || errors.Is(err, lib.ErrNotFound)
+}
+
+// errorKinds are the kinds of error the error summary groups errors by, in the order they are listed
+var errorKinds = []struct {
+	name  string
+	match func(error) bool
+}{
+	{"authentication failed", func(err error) bool { return errors.Is(err, lib.ErrAuth) }},
+	{"forbidden", func(err error) bool { return errors.Is(err, lib.ErrForbidden) }},
+	{"not found", func(err error) bool { return errors.Is(err, lib.ErrNotFound) }},
+	{"throttled", func(err error) bool { return errors.Is(err, lib.ErrThrottled) }},
+	{"wrong content type", func(err error) bool {
+		var contentTypeErr *lib.ContentTypeError
+		return errors.As(err, &contentTypeErr)
+	}},
+	{"undecodable", func(err error) bool {
+		var decodeErr *lib.DecodeError
+		return errors.As(err, &decodeErr)
+	}},
+	{"other", func(error) bool { return true }},
+}
+
+// logErrorSummary logs every error in errs with its kind, then how many errors there were of each kind
+func logErrorSummary(errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	counts := make([]int, len(errorKinds))
+	for _, err := range errs {
+		for i, kind := range errorKinds {
+			if kind.match(err) {
+				counts[i]++
+				logger.Error(err.Error(), "kind", kind.name)
+				break
+			}
+		}
+	}
+	fields := []interface{}{"errors", len(errs)}
+	for i, kind := range errorKinds {
+		if counts[i] > 0 {
+			fields = append(fields, strings.ReplaceAll(kind.name, " ", "_"), counts[i])
+		}
+	}
+	logger.Warn("Error summary", fields...)
+}
+
 func init() {
 	rootCmd.AddCommand(downloadCmd)
+	downloadCmd.Flags().BoolVar(&currentTerm, "current-term", false, "only download courses whose term is running now")
+	downloadCmd.Flags().IntVarP(&jobs, "jobs", "j", lib.DefaultJobs, "number of files to download concurrently")
+	downloadCmd.Flags().BoolVar(&incremental, "incremental", false, "re-download files whose Canvas metadata changed since the last run")
+	downloadCmd.Flags().BoolVar(&force, "force", false, "download every file, even if it is already present")
+	downloadCmd.Flags().StringVar(&layout, "layout", "", "arrange files by Canvas folder tree or module: folders|modules|flat (default flat)")
+	downloadCmd.Flags().StringVar(&minSize, "min-size", "", "only download files of at least this size, e.g. 10KB")
+	downloadCmd.Flags().StringVar(&maxSize, "max-size", "", "only download files of at most this size, e.g. 50MB")
+	downloadCmd.Flags().StringVar(&since, "since", "", "only download files updated since a date (2006-01-02), timestamp or age (14d, 2w)")
+	downloadCmd.Flags().StringVar(&until, "until", "", "only download files updated until a date (2006-01-02, inclusive), timestamp or age (14d, 2w)")
+	downloadCmd.Flags().StringVar(&mimeClass, "mime-class", "", "only download files of these comma separated Canvas MIME classes, e.g. pdf,doc")
+	downloadCmd.Flags().BoolVar(&noProgress, "no-progress", false, "do not show download progress, which is only shown on a terminal")
+	downloadCmd.Flags().StringVar(&pages, "pages", "", "also export the course's pages in these comma separated formats for reading offline: html,md,json")
+	downloadCmd.Flags().StringVar(&assignments, "assignments", "", "also export the course's assignments in these comma separated formats (html,md,json) and download the files they refer to")
+	downloadCmd.Flags().StringVar(&discussions, "discussions", "", "also export the course's announcements and discussions with their replies in these comma separated formats (html,md,json) and download their attachments")
+	downloadCmd.Flags().StringVarP(&output, "output", "o", "", "output directory, or a path template such as 'out/{{.Term}}/{{.CourseCode}}/{{.Module}}/{{.Position}}-{{.DisplayName}}' (default out)")
 
 	// Here you will define your flags and configuration settings.
 
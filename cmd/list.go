@@ -18,35 +18,37 @@ package cmd
 import (
 	"fmt"
 
-	lib "github.com/barrett370/go-canvas-cUrl/lib"
 	"github.com/spf13/cobra"
 )
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
-	Use:   "list",
+	Use:   "list [course selectors...]",
 	Short: "Lists all enrolled modules",
-	Long:  ``,
-	Run: func(cmd *cobra.Command, args []string) {
-		requester, err := lib.GetRequester()
+	Long:  courseSelectorHelp,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		ctx, cancel := runContext()
+		defer cancel()
+		requester, err := newRequester()
 		if err != nil {
-			panic(fmt.Errorf("Error getting requester: %s", err))
+			return fmt.Errorf("getting requester: %w", err)
 		}
-		courses, err := lib.GetCourses(requester, make([]string, 0))
+		courses, err := selectCourses(ctx, requester, args)
 		if err != nil {
-			fmt.Println("ERROR")
-
+			return stopped(ctx, fmt.Errorf("getting courses: %w", err))
 		}
 		for _, course := range courses {
-			fmt.Println("", course.Name)
+			fmt.Printf("%d\t%s\t%s\n", course.ID, course.CourseCode, course.Name)
 
 		}
-
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolVar(&currentTerm, "current-term", false, "only list courses whose term is running now")
 
 	// Here you will define your flags and configuration settings.
 
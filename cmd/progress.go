@@ -0,0 +1,227 @@
+/*
+Copyright © 2021 Sam Barrett <barrett370@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	lib "github.com/barrett370/go-canvas-cUrl/lib"
+)
+
+// progressInterval is how often the progress display is redrawn
+const progressInterval = 200 * time.Millisecond
+
+// progressDisplay draws the progress of a download run on a terminal: a line with the overall
+// files, bytes, throughput and ETA, followed by a line for each file being downloaded.
+// Log lines written through it are printed above the display.
+type progressDisplay struct {
+	out io.Writer
+	log io.Writer
+
+	mu          sync.Mutex
+	start       time.Time
+	active      []*fileProgress
+	totalFiles  int
+	doneFiles   int
+	totalBytes  int64
+	doneBytes   int64
+	transferred int64
+	lines       int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type fileProgress struct {
+	file    lib.File
+	written int64
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressDisplay starts drawing progress to out, printing log lines written to it to log
+func newProgressDisplay(out, log io.Writer) *progressDisplay {
+	p := &progressDisplay{
+		out:   out,
+		log:   log,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				p.redraw()
+				p.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Close stops redrawing and removes the display, leaving the log lines printed above it
+func (p *progressDisplay) Close() {
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// Write prints a log line above the display
+func (p *progressDisplay) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.log.Write(b)
+	p.redraw()
+	return n, err
+}
+
+func (p *progressDisplay) Queued(file lib.File) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totalFiles++
+	p.totalBytes += int64(file.Size)
+}
+
+func (p *progressDisplay) Started(file lib.File) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = append(p.active, &fileProgress{file: file})
+}
+
+func (p *progressDisplay) Transferred(file lib.File, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transferred += n
+	p.doneBytes += n
+	for _, f := range p.active {
+		if f.file.ID == file.ID {
+			f.written += n
+		}
+	}
+}
+
+func (p *progressDisplay) Finished(result lib.DownloadResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneFiles++
+	// skipped, failed and resumed files still count fully towards the total
+	written := int64(0)
+	for i, f := range p.active {
+		if f.file.ID == result.File.ID {
+			written = f.written
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			break
+		}
+	}
+	if rest := int64(result.File.Size) - written; rest > 0 {
+		p.doneBytes += rest
+	}
+}
+
+// clear erases the lines drawn last time, leaving the cursor where the display started
+func (p *progressDisplay) clear() {
+	if p.lines == 0 {
+		return
+	}
+	fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.lines)
+	p.lines = 0
+}
+
+// redraw replaces the display with the current progress
+func (p *progressDisplay) redraw() {
+	p.clear()
+	if p.totalFiles == 0 {
+		return
+	}
+	var b strings.Builder
+	elapsed := time.Since(p.start)
+	rate := float64(p.transferred) / elapsed.Seconds()
+	eta := "--:--"
+	if rate > 0 && p.doneBytes < p.totalBytes {
+		eta = formatETA(time.Duration(float64(p.totalBytes-p.doneBytes) / rate * float64(time.Second)))
+	}
+	fmt.Fprintf(&b, "[%d/%d files] %s / %s  %s/s  ETA %s\n", p.doneFiles, p.totalFiles,
+		formatBytes(p.doneBytes), formatBytes(p.totalBytes), formatBytes(int64(rate)), eta)
+	for _, f := range p.active {
+		fmt.Fprintf(&b, "  %-40s %s %s / %s\n", truncate(f.file.DisplayName, 40), bar(f.written, int64(f.file.Size), 20),
+			formatBytes(f.written), formatBytes(int64(f.file.Size)))
+	}
+	io.WriteString(p.out, b.String())
+	p.lines = 1 + len(p.active)
+}
+
+// bar draws a progress bar width characters wide, followed by the percentage done
+func bar(done, total int64, width int) string {
+	if total <= 0 {
+		return "[" + strings.Repeat(" ", width) + "]   ?%"
+	}
+	if done > total {
+		done = total
+	}
+	filled := int(done * int64(width) / total)
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), done*100/total)
+}
+
+// formatBytes formats n bytes with a binary unit, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA formats d as minutes and seconds, or hours and minutes once it exceeds an hour
+func formatETA(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// truncate shortens s to at most n characters, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
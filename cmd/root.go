@@ -16,15 +16,37 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/spf13/cobra"
 
-	homedir "github.com/mitchellh/go-homedir"
+	lib "github.com/barrett370/go-canvas-cUrl/lib"
 	"github.com/spf13/viper"
 )
 
-var cfgFile string
+var (
+	cfgFile  string
+	instance string
+	baseURL  string
+	// config is the config file read by newRequester
+	config *viper.Viper
+	// currentTerm restricts list and download to courses running now
+	currentTerm bool
+	// timeout bounds a whole run, zero means no limit
+	timeout time.Duration
+	// quiet, verbose and logFormat configure logger
+	quiet     bool
+	verbose   int
+	logFormat string
+	// logger receives every event, it is set up before any command runs
+	logger *lib.Logger
+)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -39,47 +61,180 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogger()
+	},
+	// errors are logged by Execute, so they follow --log-format
+	SilenceErrors: true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	if err := rootCmd.Execute(); err != nil {
+		if logger != nil {
+			logger.Error(err.Error())
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(exitCode(err))
+	}
 }
 
-func init() {
-	cobra.OnInitialize(initConfig)
+// setupLogger builds logger from the --quiet, --verbose and --log-format flags
+func setupLogger() error {
+	format, err := lib.ParseLogFormat(logFormat)
+	if err != nil {
+		return usageError(err)
+	}
+	level := lib.LevelInfo
+	switch {
+	case quiet && verbose > 0:
+		return usageError(errors.New("--quiet and --verbose cannot be used together"))
+	case quiet:
+		level = lib.LevelWarn
+	case verbose == 1:
+		level = lib.LevelDebug
+	case verbose > 1:
+		level = lib.LevelTrace
+	}
+	logger = lib.NewLogger(os.Stderr, level, format)
+	return nil
+}
+
+// Exit codes, besides 0 for success
+const (
+	// exitFailure is returned for any error without a more specific code
+	exitFailure = 1
+	// exitUsage is returned for invalid flags, arguments or config values
+	exitUsage = 2
+	// exitAuth is returned when Canvas rejects the access token
+	exitAuth = 3
+	// exitPartial is returned when a run finished, but some files could not be found or downloaded
+	exitPartial = 4
+	// exitTimeout is returned when --timeout passed before the run finished
+	exitTimeout = 124
+	// exitInterrupted is returned when the run was stopped by SIGINT or SIGTERM
+	exitInterrupted = 130
+)
+
+// exitError gives err a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// usageError marks err as caused by invalid flags, arguments or config values
+func usageError(err error) error {
+	return &exitError{code: exitUsage, err: err}
+}
+
+// exitCode returns the exit code the program ends with after err
+func exitCode(err error) int {
+	var exitErr *exitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.Is(err, lib.ErrAuth):
+		return exitAuth
+	}
+	return exitFailure
+}
 
+// stopped gives err the exit code for why ctx ended, if it has.
+// Requests time out on their own as well, so only the run context says whether --timeout or a signal stopped the run.
+func stopped(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return &exitError{code: exitTimeout, err: err}
+	case context.Canceled:
+		return &exitError{code: exitInterrupted, err: err}
+	}
+	return err
+}
+
+func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.go-canvas-cUrl.yaml)")
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return usageError(err)
+	})
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is config.yaml in the working directory)")
+	rootCmd.PersistentFlags().StringVar(&instance, "instance", os.Getenv("CANVAS_INSTANCE"), "named Canvas instance from the config file's Instances section")
+	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", os.Getenv("CANVAS_BASE_URL"), "Canvas base URL including scheme, e.g. https://canvas.example.edu")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only log warnings and errors")
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "log every decision made about each file, or with -vv every request")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format: text|json")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "give up after this long, e.g. 30m (default no limit)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		cobra.CheckErr(err)
-
-		// Search config in home directory with name ".go-canvas-cUrl" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".go-canvas-cUrl")
+// newRequester reads the config file and builds a Requester for the selected Canvas instance
+func newRequester() (lib.Requester, error) {
+	var err error
+	config, err = lib.ReadConfig(cfgFile)
+	if err != nil {
+		return lib.Requester{}, err
 	}
+	logger.Debug("Using config file", "path", config.ConfigFileUsed())
+	return lib.GetRequester(config, instance, baseURL, logger)
+}
+
+// runContext returns a context which is cancelled on SIGINT or SIGTERM, or once --timeout has passed.
+// After the first signal the default handling is restored, so a second one exits immediately.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
 
-	viper.AutomaticEnv() // read in environment variables that match
+// configString returns flag if it was given, otherwise key from the config of r's instance
+func configString(r lib.Requester, flag, key string) string {
+	if flag != "" || config == nil {
+		return flag
+	}
+	return lib.ProfileString(config, r.Instance, key)
+}
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+// selectCourses lists the courses picked by the course selectors in args
+func selectCourses(ctx context.Context, r lib.Requester, args []string) ([]lib.Course, error) {
+	selection, err := lib.ParseCourseSelection(args, currentTerm)
+	if err != nil {
+		return nil, usageError(err)
 	}
+	return lib.GetCourses(ctx, r, selection)
 }
+
+// courseSelectorHelp documents the course selectors accepted by list and download
+const courseSelectorHelp = `Courses are selected by name (ignoring case and spaces) or by selectors:
+	id:<id>             the course with this ID
+	code:<code>         courses with this course code
+	term:<id|name>      courses in this enrollment term
+	state:<state>       courses in this workflow state, e.g. available
+	enrollment:<type>   courses you are enrolled in as this type, e.g. student
+	name:<regexp>       courses whose name matches the regular expression
+A course is picked if it matches any selector; --current-term further restricts
+the selection to courses whose term is running now.`
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assignment is an assignment set in a course
+type Assignment struct {
+	ID              int       `json:"id"`
+	CourseID        int       `json:"course_id"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	DueAt           time.Time `json:"due_at"`
+	UnlockAt        time.Time `json:"unlock_at"`
+	LockAt          time.Time `json:"lock_at"`
+	PointsPossible  float64   `json:"points_possible"`
+	GradingType     string    `json:"grading_type"`
+	SubmissionTypes []string  `json:"submission_types"`
+	Position        int       `json:"position"`
+	HTMLURL         string    `json:"html_url"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Published       bool      `json:"published"`
+}
+
+// GetAssignments schedules the files referred to by the description of every assignment in course
+// for download, and adds the assignments to e, which may be nil
+func (course *Course) GetAssignments(ctx context.Context, r Requester, s *Scheduler, e *Exporter) error {
+	assignments, err := r.ListAssignments(ctx, course.ID)
+	if err != nil {
+		return err
+	}
+	var failed []error
+	for _, assignment := range assignments {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := getAssignmentFiles(ctx, r, s, e, FileSource{Course: *course}, assignment)
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d assignments had problems, the first: %w", len(failed), len(assignments), failed[0])
+	}
+	return nil
+}
+
+// getAssignmentFiles adds assignment, found through source, to e and schedules the files its
+// description refers to for download
+func getAssignmentFiles(ctx context.Context, r Requester, s *Scheduler, e *Exporter, source FileSource, assignment Assignment) error {
+	e.AddAssignment(source.Course, assignment)
+	var failed []error
+	for _, id := range ExtractFileIDs(assignment.Description, r.host()) {
+		file, err := r.GetCourseFile(ctx, source.Course.ID, id)
+		if err != nil {
+			failed = append(failed, err)
+			continue
+		}
+		r.Log.Debug("Found linked file", "course", source.Course.Name, "module", source.Module.Name,
+			"assignment", assignment.Name, "file_id", file.ID, "name", file.DisplayName)
+		s.Add(source, file)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("assignment %s: %d linked files could not be fetched, the first: %w", assignment.Name, len(failed), failed[0])
+	}
+	return nil
+}
+
+// submissionTypeNames are the readable names of Canvas' submission types
+var submissionTypeNames = map[string]string{
+	"discussion_topic":   "discussion",
+	"external_tool":      "external tool",
+	"media_recording":    "media recording",
+	"none":               "no submission",
+	"on_paper":           "on paper",
+	"online_quiz":        "quiz",
+	"online_text_entry":  "text entry",
+	"online_upload":      "file upload",
+	"online_url":         "website URL",
+	"student_annotation": "student annotation",
+	"wiki_page":          "page",
+}
+
+// AddAssignment adds assignment if assignments are exported. Its description is exported with its
+// due date, points and submission types to assignments/<ID>-<name> within the course's directory.
+func (e *Exporter) AddAssignment(course Course, assignment Assignment) {
+	if !e.exports(assignmentsDir) {
+		return
+	}
+	var meta []metaField
+	if !assignment.DueAt.IsZero() {
+		meta = append(meta, metaField{"due_at", "Due", assignment.DueAt})
+	}
+	if !assignment.UnlockAt.IsZero() {
+		meta = append(meta, metaField{"unlock_at", "Available from", assignment.UnlockAt})
+	}
+	if !assignment.LockAt.IsZero() {
+		meta = append(meta, metaField{"lock_at", "Available until", assignment.LockAt})
+	}
+	if assignment.GradingType != "not_graded" {
+		meta = append(meta, metaField{"points_possible", "Points", assignment.PointsPossible})
+	}
+	if len(assignment.SubmissionTypes) > 0 {
+		types := make([]string, len(assignment.SubmissionTypes))
+		for i, t := range assignment.SubmissionTypes {
+			types[i] = t
+			if name, ok := submissionTypeNames[t]; ok {
+				types[i] = name
+			}
+		}
+		meta = append(meta, metaField{"submission_types", "Submission", types})
+	}
+	if !assignment.UpdatedAt.IsZero() {
+		meta = append(meta, metaField{"updated_at", "Updated", assignment.UpdatedAt})
+	}
+	id := strconv.Itoa(assignment.ID)
+	e.add(&document{
+		course:  course,
+		kind:    assignmentsDir,
+		id:      id,
+		name:    id + "-" + strings.ReplaceAll(assignment.Name, " ", ""),
+		title:   assignment.Name,
+		htmlURL: assignment.HTMLURL,
+		meta:    meta,
+		body:    assignment.Description,
+		formats: e.opts.Assignments,
+		data:    assignment,
+	})
+}
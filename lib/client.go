@@ -0,0 +1,278 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultUserAgent is sent with every request unless the Client is given another
+const DefaultUserAgent = "go-canvas-cUrl"
+
+// DefaultTimeout bounds each API request unless the Client is given another
+const DefaultTimeout = time.Minute
+
+// Middleware wraps the transport a Client sends its requests through, e.g. to log or record them
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Client talks to the REST API of a single Canvas instance. Create one with NewClient.
+type Client struct {
+	// BaseURL is the scheme and host of the Canvas instance, e.g. https://canvas.bham.ac.uk
+	BaseURL string
+	// Token is the access token sent as a bearer token with every request
+	Token string
+	// UserAgent is sent with every request
+	UserAgent string
+	// Timeout bounds each API request, zero means no limit. File downloads are not bounded,
+	// so large files can take as long as they need.
+	Timeout time.Duration
+	// HTTP sends every request
+	HTTP *http.Client
+
+	noRetry    bool
+	middleware []Middleware
+}
+
+// ClientOption configures a Client built by NewClient
+type ClientOption func(*Client)
+
+// WithHTTPClient sends requests through a copy of hc, wrapping its transport with retries and middleware
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.HTTP = hc
+	}
+}
+
+// WithUserAgent sends userAgent instead of DefaultUserAgent
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.UserAgent = userAgent
+	}
+}
+
+// WithTimeout bounds each API request by timeout instead of DefaultTimeout, zero means no limit
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.Timeout = timeout
+	}
+}
+
+// WithMiddleware wraps the client's transport with each of middleware, the first being outermost.
+// Middleware sees each request once, however many times it is retried.
+func WithMiddleware(middleware ...Middleware) ClientOption {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// WithoutRetries sends each request once, instead of retrying throttled and failed requests
+func WithoutRetries() ClientOption {
+	return func(c *Client) {
+		c.noRetry = true
+	}
+}
+
+// NewClient builds a Client for the Canvas instance at baseURL, authenticating with token
+func NewClient(baseURL, token string, opts ...ClientOption) *Client {
+	c := &Client{
+		BaseURL:   normaliseBaseURL(baseURL),
+		Token:     token,
+		UserAgent: DefaultUserAgent,
+		Timeout:   DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	hc := &http.Client{}
+	if c.HTTP != nil {
+		copied := *c.HTTP
+		hc = &copied
+	}
+	transport := hc.Transport
+	if !c.noRetry {
+		transport = newRetryTransport(transport)
+	}
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		transport = c.middleware[i](transport)
+	}
+	hc.Transport = transport
+	c.HTTP = hc
+	return c
+}
+
+// Do sends req with the client's authorization and user agent
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	return c.HTTP.Do(req)
+}
+
+// ListCourses lists the courses you are enrolled in, with their terms
+func (c *Client) ListCourses(ctx context.Context) ([]Course, error) {
+	courses := make([]Course, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses?include[]=term"), &courses)
+	return courses, err
+}
+
+// ListModules lists the modules of the course with ID courseID
+func (c *Client) ListModules(ctx context.Context, courseID int) ([]Module, error) {
+	modules := make([]Module, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/modules"), &modules)
+	return modules, err
+}
+
+// ListModuleItems lists the items of a module
+func (c *Client) ListModuleItems(ctx context.Context, courseID, moduleID int) ([]Folder, error) {
+	items := make([]Folder, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/modules/"+strconv.Itoa(moduleID)+"/items"), &items)
+	return items, err
+}
+
+// ListFiles lists every file in the files area of the course with ID courseID
+func (c *Client) ListFiles(ctx context.Context, courseID int) ([]File, error) {
+	files := make([]File, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/files"), &files)
+	return files, err
+}
+
+// ListFolders lists every folder in the files area of the course with ID courseID
+func (c *Client) ListFolders(ctx context.Context, courseID int) ([]CourseFolder, error) {
+	folders := make([]CourseFolder, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/folders"), &folders)
+	return folders, err
+}
+
+// GetFile fetches the file with ID fileID
+func (c *Client) GetFile(ctx context.Context, fileID int) (File, error) {
+	var file File
+	err := c.getJSON(ctx, c.apiURL("/files/"+strconv.Itoa(fileID)), &file)
+	return file, err
+}
+
+// GetCourseFile fetches the file with ID fileID through the course with ID courseID, which also works
+// for files only visible within the course
+func (c *Client) GetCourseFile(ctx context.Context, courseID, fileID int) (File, error) {
+	var file File
+	err := c.getJSON(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/files/"+strconv.Itoa(fileID)), &file)
+	return file, err
+}
+
+// ListAssignments lists the assignments of the course with ID courseID
+func (c *Client) ListAssignments(ctx context.Context, courseID int) ([]Assignment, error) {
+	assignments := make([]Assignment, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/assignments"), &assignments)
+	return assignments, err
+}
+
+// GetAssignment fetches the assignment with ID assignmentID of the course with ID courseID
+func (c *Client) GetAssignment(ctx context.Context, courseID, assignmentID int) (Assignment, error) {
+	var assignment Assignment
+	err := c.getJSON(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/assignments/"+strconv.Itoa(assignmentID)), &assignment)
+	return assignment, err
+}
+
+// ListDiscussionTopics lists the discussion topics of the course with ID courseID, without its announcements
+func (c *Client) ListDiscussionTopics(ctx context.Context, courseID int) ([]DiscussionTopic, error) {
+	topics := make([]DiscussionTopic, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/discussion_topics"), &topics)
+	return topics, err
+}
+
+// ListAnnouncements lists the announcements of the course with ID courseID
+func (c *Client) ListAnnouncements(ctx context.Context, courseID int) ([]DiscussionTopic, error) {
+	topics := make([]DiscussionTopic, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/discussion_topics?only_announcements=true"), &topics)
+	return topics, err
+}
+
+// GetDiscussionView fetches every entry of the discussion topic or announcement with ID topicID of
+// the course with ID courseID, threaded
+func (c *Client) GetDiscussionView(ctx context.Context, courseID, topicID int) (DiscussionView, error) {
+	var view DiscussionView
+	err := c.getJSON(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/discussion_topics/"+strconv.Itoa(topicID)+"/view"), &view)
+	return view, err
+}
+
+// ListPages lists the pages in the Pages area of the course with ID courseID, without their bodies
+func (c *Client) ListPages(ctx context.Context, courseID int) ([]Page, error) {
+	pages := make([]Page, 0)
+	err := c.getPaginated(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/pages"), &pages)
+	return pages, err
+}
+
+// GetPage fetches a course's page by its URL slug or ID
+func (c *Client) GetPage(ctx context.Context, courseID int, page string) (Page, error) {
+	var p Page
+	err := c.getJSON(ctx, c.apiURL("/courses/"+strconv.Itoa(courseID)+"/pages/"+url.PathEscape(page)), &p)
+	return p, err
+}
+
+// apiURL returns the absolute URL of path below the instance's /api/v1
+func (c *Client) apiURL(path string) string {
+	return c.BaseURL + "/api/v1" + path
+}
+
+// host returns the host (and port, if any) of the Canvas instance c talks to
+func (c *Client) host() string {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return c.BaseURL
+	}
+	return u.Host
+}
+
+// get requests rawURL within the client's timeout, returning the response and its whole body,
+// or an APIError if the response has an error status
+func (c *Client) get(ctx context.Context, rawURL string) (*http.Response, []byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, bodyError(resp, body)
+	}
+	return resp, body, nil
+}
+
+// getJSON requests rawURL and decodes the response into out
+func (c *Client) getJSON(ctx context.Context, rawURL string, out interface{}) error {
+	resp, body, err := c.get(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	return decode(resp, body, out)
+}
+
+// decode unmarshals body, the body of the successful response resp, into out
+func decode(resp *http.Response, body []byte, out interface{}) error {
+	err := json.Unmarshal(body, out)
+	if err != nil {
+		return &DecodeError{URL: resp.Request.URL.String(), Err: err}
+	}
+	return nil
+}
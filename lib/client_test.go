@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientPagination(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer tok" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer tok")
+		}
+		if got := r.URL.Query().Get("per_page"); got != "100" {
+			t.Errorf("per_page = %q, want 100", got)
+		}
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/courses?page=2&per_page=100>; rel="next", <%s/api/v1/courses?page=3&per_page=100>; rel="last"`, srv.URL, srv.URL))
+			fmt.Fprint(w, `[{"id":1,"name":"One"},{"id":2,"name":"Two"}]`)
+		case "2":
+			// relative targets are resolved against the request
+			w.Header().Set("Link", `</api/v1/courses?page=3&per_page=100>; rel="next"`)
+			fmt.Fprint(w, `[{"id":3,"name":"Three"}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer srv.Close()
+
+	courses, err := NewClient(srv.URL, "tok").ListCourses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, course := range courses {
+		ids = append(ids, course.ID)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("course IDs = %v, want %v", ids, want)
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	tests := []struct {
+		name  string
+		links []string
+		want  string
+	}{
+		{"none", nil, ""},
+		{"last page", []string{`<https://c.test/api/v1/courses?page=1>; rel="first", <https://c.test/api/v1/courses?page=1>; rel="last"`}, ""},
+		{"next", []string{`<https://c.test/api/v1/courses?page=1>; rel="current",<https://c.test/api/v1/courses?page=2>; rel="next"`},
+			"https://c.test/api/v1/courses?page=2"},
+		{"unquoted and several rels", []string{`<https://c.test/a?page=2>; rel=next`}, "https://c.test/a?page=2"},
+		{"relative", []string{`</api/v1/courses?page=2>; rel="prev next"`}, "https://c.test/api/v1/courses?page=2"},
+		{"separate headers", []string{`<https://c.test/a?page=1>; rel="first"`, `<https://c.test/a?page=3>; rel="next"`},
+			"https://c.test/a?page=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqURL, _ := url.Parse("https://c.test/api/v1/courses?page=1")
+			resp := &http.Response{Header: http.Header{"Link": tt.links}, Request: &http.Request{URL: reqURL}}
+			if got := nextPage(resp); got != tt.want {
+				t.Errorf("nextPage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "403 Forbidden (Rate Limit Exceeded)")
+		default:
+			fmt.Fprint(w, `{"id":7,"filename":"notes.pdf"}`)
+		}
+	}))
+	defer srv.Close()
+
+	file, err := NewClient(srv.URL, "tok").GetFile(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Filename != "notes.pdf" {
+		t.Errorf("Filename = %q, want notes.pdf", file.Filename)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	_, err = NewClient(srv.URL, "tok", WithoutRetries()).GetFile(context.Background(), 7)
+	if err == nil {
+		t.Error("got no error without retries")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("sent %d requests without retries, want 1", n)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		retry  bool
+		wait   time.Duration
+	}{
+		{"ok", 200, nil, "", false, 0},
+		{"not found", 404, nil, "", false, 0},
+		{"forbidden", 403, nil, `{"errors":[{"message":"forbidden"}]}`, false, 0},
+		{"server error", 502, nil, "", true, 0},
+		{"throttled", 429, http.Header{"Retry-After": {"3"}}, "", true, 3 * time.Second},
+		{"rate limit exceeded", 403, nil, "403 Forbidden (Rate Limit Exceeded)", true, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: tt.header, Body: ioutil.NopCloser(strings.NewReader(tt.body))}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+		retry, wait := shouldRetry(resp, nil)
+		if retry != tt.retry || wait != tt.wait {
+			t.Errorf("%s: shouldRetry() = %v, %v, want %v, %v", tt.name, retry, wait, tt.retry, tt.wait)
+		}
+		// the body is left for the caller to read
+		if body, _ := ioutil.ReadAll(resp.Body); string(body) != tt.body {
+			t.Errorf("%s: body = %q after shouldRetry, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 70; attempt++ {
+		d := backoff(attempt)
+		if d <= 0 || d > maxBackoff {
+			t.Errorf("backoff(%d) = %v, want within (0, %v]", attempt, d, maxBackoff)
+		}
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "tester" {
+			t.Errorf("User-Agent = %q, want tester", got)
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	var seen int32
+	count := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&seen, 1)
+			return next.RoundTrip(req)
+		})
+	}
+	hc := &http.Client{Transport: http.DefaultTransport}
+	c := NewClient(srv.URL, "tok", WithHTTPClient(hc), WithUserAgent("tester"), WithMiddleware(count))
+	if _, err := c.ListModules(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("server saw %d requests, want 2", n)
+	}
+	if n := atomic.LoadInt32(&seen); n != 1 {
+		t.Errorf("middleware saw %d requests, want 1", n)
+	}
+	if hc.Transport != http.DefaultTransport {
+		t.Error("NewClient changed the transport of the given http.Client")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "tok", WithTimeout(50*time.Millisecond), WithoutRetries()).ListModules(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline exceeded error", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		authenticate bool
+		body         string
+		kind         error
+		messages     []string
+	}{
+		{"bad token", 401, true, `{"errors":[{"message":"Invalid access token."}]}`, ErrAuth, []string{"Invalid access token."}},
+		{"unauthorized action", 401, false, `{"status":"unauthorized","errors":[{"message":"user not authorized to perform that action"}]}`,
+			ErrForbidden, []string{"user not authorized to perform that action"}},
+		{"forbidden", 403, false, `{"message":"Tab disabled"}`, ErrForbidden, []string{"Tab disabled"}},
+		{"not found", 404, false, `{"errors":[{"message":"The specified resource does not exist."}]}`, ErrNotFound,
+			[]string{"The specified resource does not exist."}},
+		{"throttled", 403, false, `{"errors":[{"message":"Rate Limit Exceeded"}]}`, ErrThrottled, []string{"Rate Limit Exceeded"}},
+		{"field errors", 400, false, `{"errors":{"name":[{"message":"too long"}],"code":[{"message":"taken"}]}}`, nil,
+			[]string{"code: taken", "name: too long"}},
+		{"not json", 500, false, `<html>oops</html>`, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.authenticate {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="canvas-lms"`)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			_, err := NewClient(srv.URL, "tok", WithoutRetries()).ListModules(context.Background(), 1)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			if kind := apiErr.Unwrap(); kind != tt.kind {
+				t.Errorf("kind = %v, want %v", kind, tt.kind)
+			}
+			if !reflect.DeepEqual(apiErr.Messages, tt.messages) {
+				t.Errorf("Messages = %q, want %q", apiErr.Messages, tt.messages)
+			}
+		})
+	}
+}
+
+func TestClientDecodeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"seven"}`)
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "tok").GetFile(context.Background(), 7)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("got %v, want a DecodeError", err)
+	}
+}
+
+func TestLogRequestsStripsQuery(t *testing.T) {
+	var out strings.Builder
+	transport := LogRequests(NewLogger(&out, LevelTrace, LogText))(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.RawQuery == "" {
+			t.Error("the query was removed from the request itself")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	req := httptest.NewRequest(http.MethodGet, "https://canvas.example.edu/files/1/download?verifier=secret&Signature=sig", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); strings.Contains(got, "secret") || strings.Contains(got, "sig") || !strings.Contains(got, "https://canvas.example.edu/files/1/download") {
+		t.Errorf("logged %q, want the URL without its query", got)
+	}
+}
@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscussionTopic is a discussion topic or an announcement of a course
+type DiscussionTopic struct {
+	ID       int       `json:"id"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	HTMLURL  string    `json:"html_url"`
+	PostedAt time.Time `json:"posted_at"`
+	// LastReplyAt is when the latest entry was posted
+	LastReplyAt time.Time `json:"last_reply_at"`
+	UserName    string    `json:"user_name"`
+	Author      struct {
+		ID          int    `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"author"`
+	// DiscussionType is side_comment for topics whose entries cannot be replied to, threaded otherwise
+	DiscussionType          string `json:"discussion_type"`
+	DiscussionSubentryCount int    `json:"discussion_subentry_count"`
+	Attachments             []File `json:"attachments"`
+	// Entries are the topic's entries with their replies, which are fetched separately
+	Entries []DiscussionEntry `json:"entries,omitempty"`
+}
+
+// DiscussionEntry is an entry, or reply, in a discussion topic
+type DiscussionEntry struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// UserName is filled in from the participants of the topic's view
+	UserName    string            `json:"user_name"`
+	Message     string            `json:"message"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Deleted     bool              `json:"deleted"`
+	Attachment  *File             `json:"attachment,omitempty"`
+	Attachments []File            `json:"attachments,omitempty"`
+	Replies     []DiscussionEntry `json:"replies,omitempty"`
+}
+
+// DiscussionView is the full, threaded view of a discussion topic's entries
+type DiscussionView struct {
+	Participants []struct {
+		ID          int    `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"participants"`
+	View []DiscussionEntry `json:"view"`
+}
+
+// Entries returns the view's entries with the names of their authors filled in
+func (v DiscussionView) Entries() []DiscussionEntry {
+	names := make(map[int]string, len(v.Participants))
+	for _, p := range v.Participants {
+		names[p.ID] = p.DisplayName
+	}
+	var name func([]DiscussionEntry) []DiscussionEntry
+	name = func(entries []DiscussionEntry) []DiscussionEntry {
+		named := make([]DiscussionEntry, len(entries))
+		for i, entry := range entries {
+			if entry.UserName == "" {
+				entry.UserName = names[entry.UserID]
+			}
+			entry.Replies = name(entry.Replies)
+			named[i] = entry
+		}
+		return named
+	}
+	return name(v.View)
+}
+
+// attached returns the files attached to entry itself
+func (entry DiscussionEntry) attached() []File {
+	files := append([]File(nil), entry.Attachments...)
+	if entry.Attachment != nil {
+		files = append(files, *entry.Attachment)
+	}
+	return files
+}
+
+// GetDiscussions schedules the files attached to, or linked from, every discussion topic and
+// announcement of course and their entries for download, and adds them to e, which may be nil
+func (course *Course) GetDiscussions(ctx context.Context, r Requester, s *Scheduler, e *Exporter) error {
+	var failed []error
+	for _, kind := range []string{announcementsDir, discussionsDir} {
+		var topics []DiscussionTopic
+		var err error
+		if kind == announcementsDir {
+			topics, err = r.ListAnnouncements(ctx, course.ID)
+		} else {
+			topics, err = r.ListDiscussionTopics(ctx, course.ID)
+		}
+		if err != nil {
+			return err
+		}
+		for _, topic := range topics {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			err := getTopicFiles(ctx, r, s, e, FileSource{Course: *course}, kind, topic)
+			if err != nil {
+				failed = append(failed, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d discussion topics and announcements had problems, the first: %w", len(failed), failed[0])
+	}
+	return nil
+}
+
+// getTopicFiles fetches the entries of topic, found through source, adds it to e as kind, either
+// announcementsDir or discussionsDir, and schedules the files attached to or linked from it for download
+func getTopicFiles(ctx context.Context, r Requester, s *Scheduler, e *Exporter, source FileSource, kind string, topic DiscussionTopic) error {
+	var failed []error
+	if topic.DiscussionSubentryCount > 0 {
+		view, err := r.GetDiscussionView(ctx, source.Course.ID, topic.ID)
+		if errors.Is(err, ErrForbidden) {
+			// topics which require an initial post hide their entries until one is made
+			r.Log.Debug("Discussion entries not available", "course", source.Course.Name, "topic", topic.Title, "error", err)
+		} else if err != nil {
+			failed = append(failed, fmt.Errorf("fetching entries: %w", err))
+		}
+		topic.Entries = view.Entries()
+	}
+	e.addTopic(source.Course, kind, topic)
+
+	files := append([]File(nil), topic.Attachments...)
+	bodies := []string{topic.Message}
+	var visit func([]DiscussionEntry)
+	visit = func(entries []DiscussionEntry) {
+		for _, entry := range entries {
+			files = append(files, entry.attached()...)
+			bodies = append(bodies, entry.Message)
+			visit(entry.Replies)
+		}
+	}
+	visit(topic.Entries)
+	attached := make(map[int]bool)
+	for _, file := range files {
+		attached[file.ID] = true
+		r.Log.Debug("Found attached file", "course", source.Course.Name, "topic", topic.Title, "file_id", file.ID, "name", file.DisplayName)
+		s.Add(source, file)
+	}
+	for _, body := range bodies {
+		for _, id := range ExtractFileIDs(body, r.host()) {
+			if attached[id] {
+				continue
+			}
+			attached[id] = true
+			file, err := r.GetCourseFile(ctx, source.Course.ID, id)
+			if err != nil {
+				failed = append(failed, err)
+				continue
+			}
+			r.Log.Debug("Found linked file", "course", source.Course.Name, "topic", topic.Title, "file_id", file.ID, "name", file.DisplayName)
+			s.Add(source, file)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s %s: %d problems, the first: %w", strings.TrimSuffix(kind, "s"), topic.Title, len(failed), failed[0])
+	}
+	return nil
+}
+
+// addTopic adds topic, with its entries, as kind, either announcementsDir or discussionsDir, if
+// discussions are exported. It is exported with its author, when it was posted and its threaded
+// entries to <kind>/<ID>-<title> within the course's directory.
+func (e *Exporter) addTopic(course Course, kind string, topic DiscussionTopic) {
+	if !e.exports(kind) {
+		return
+	}
+	var meta []metaField
+	author := topic.Author.DisplayName
+	if author == "" {
+		author = topic.UserName
+	}
+	if author != "" {
+		meta = append(meta, metaField{"author", "Posted by", author})
+	}
+	if !topic.PostedAt.IsZero() {
+		meta = append(meta, metaField{"posted_at", "Posted", topic.PostedAt})
+	}
+	if topic.DiscussionSubentryCount > 0 {
+		meta = append(meta, metaField{"entries", "Entries", topic.DiscussionSubentryCount})
+	}
+	if !topic.LastReplyAt.IsZero() && topic.DiscussionSubentryCount > 0 {
+		meta = append(meta, metaField{"last_reply_at", "Last reply", topic.LastReplyAt})
+	}
+	body, err := renderTopic(topic)
+	if err != nil {
+		e.r.Log.Warn("Could not render entries", "course", course.Name, "topic", topic.Title, "error", err)
+		body = topic.Message
+	}
+	id := strconv.Itoa(topic.ID)
+	e.add(&document{
+		course:  course,
+		kind:    kind,
+		id:      id,
+		name:    id + "-" + strings.ReplaceAll(topic.Title, " ", ""),
+		title:   topic.Title,
+		htmlURL: topic.HTMLURL,
+		meta:    meta,
+		body:    body,
+		formats: e.opts.Discussions,
+		data:    topic,
+	})
+}
+
+// topicTemplate renders a topic's message followed by its attachments and threaded entries, replies
+// being quoted within the entry they reply to so the thread survives conversion to Markdown
+var topicTemplate = template.Must(template.New("topic").Parse(`{{define "files"}}{{if .}}<ul class="attachments">
+{{range .}}<li><a href="{{.URL}}">{{.DisplayName}}</a></li>
+{{end}}</ul>
+{{end}}{{end}}{{define "entries"}}{{range .}}<article class="entry">
+<p class="entry-meta"><strong>{{if .UserName}}{{.UserName}}{{else}}Unknown{{end}}</strong>{{if not .CreatedAt.IsZero}} <time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.CreatedAt.Format "2 January 2006 15:04"}}</time>{{end}}</p>
+{{if .Deleted}}<p><em>This entry has been deleted.</em></p>
+{{else}}{{.HTMLMessage}}
+{{end}}{{template "files" .Files}}{{if .Replies}}<blockquote class="replies">
+{{template "entries" .Replies}}</blockquote>
+{{end}}</article>
+{{end}}{{end}}{{.Message}}
+{{template "files" .Attachments}}{{if .Entries}}<section class="discussion">
+<h2>Entries</h2>
+{{template "entries" .Entries}}</section>
+{{end}}`))
+
+// renderedEntry is a DiscussionEntry prepared for topicTemplate
+type renderedEntry struct {
+	UserName    string
+	CreatedAt   time.Time
+	Deleted     bool
+	HTMLMessage template.HTML
+	Files       []File
+	Replies     []renderedEntry
+}
+
+// renderTopic renders topic's message, attachments and threaded entries as HTML
+func renderTopic(topic DiscussionTopic) (string, error) {
+	var prepare func([]DiscussionEntry) []renderedEntry
+	prepare = func(entries []DiscussionEntry) []renderedEntry {
+		rendered := make([]renderedEntry, len(entries))
+		for i, entry := range entries {
+			rendered[i] = renderedEntry{
+				UserName:  entry.UserName,
+				CreatedAt: entry.CreatedAt,
+				Deleted:   entry.Deleted,
+				// Canvas sanitises messages itself
+				HTMLMessage: template.HTML(entry.Message),
+				Files:       entry.attached(),
+				Replies:     prepare(entry.Replies),
+			}
+		}
+		return rendered
+	}
+	data := struct {
+		Message     template.HTML
+		Attachments []File
+		Entries     []renderedEntry
+	}{template.HTML(topic.Message), topic.Attachments, prepare(topic.Entries)}
+	var buf bytes.Buffer
+	err := topicTemplate.Execute(&buf, data)
+	return buf.String(), err
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Kinds of APIError, test for them with errors.Is
+var (
+	// ErrAuth means Canvas rejected the access token
+	ErrAuth = errors.New("authentication failed")
+	// ErrForbidden means the token is valid but may not access the resource
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound means the resource does not exist, or is hidden from the user
+	ErrNotFound = errors.New("not found")
+	// ErrThrottled means the request was refused by Canvas' rate limiting, even after retrying
+	ErrThrottled = errors.New("throttled")
+)
+
+// APIError is returned when Canvas answers a request with an error status
+type APIError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// URL is the URL which was requested
+	URL string
+	// Status is the status Canvas gave in its error body, if any, e.g. "unauthorized"
+	Status string
+	// Messages are the messages of the errors Canvas gave in its error body, if any
+	Messages []string
+	// authenticate is set when the response asked for authentication, which Canvas only does for bad tokens
+	authenticate bool
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if len(e.Messages) > 0 {
+		msg += ": " + strings.Join(e.Messages, "; ")
+	} else if e.Status != "" {
+		msg += ": " + e.Status
+	}
+	return msg
+}
+
+// Unwrap returns the kind of the error, one of ErrAuth, ErrForbidden, ErrNotFound or ErrThrottled,
+// or nil for any other status
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized && e.authenticate:
+		return ErrAuth
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode == http.StatusForbidden && e.rateLimited():
+		return ErrThrottled
+	// Canvas answers 401 when a valid token may not perform an action
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// rateLimited reports whether Canvas' messages blame its rate limit
+func (e *APIError) rateLimited() bool {
+	for _, msg := range append([]string{e.Status}, e.Messages...) {
+		if strings.Contains(strings.ToLower(msg), "rate limit exceeded") {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeError is returned when a response cannot be decoded into the expected structure
+type DecodeError struct {
+	URL string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: decoding response: %s", e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ContentTypeError is returned when a download is refused because the server sent content of a different
+// type than Canvas recorded for the file, usually an HTML error or login page
+type ContentTypeError struct {
+	URL      string
+	Expected string
+	Got      string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("%s: expected %s but got %s", e.URL, e.Expected, e.Got)
+}
+
+// responseError reads resp's body and builds the APIError for it
+func responseError(resp *http.Response) *APIError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return bodyError(resp, body)
+}
+
+// bodyError builds the APIError for resp, with the messages from body if it is one of Canvas' error bodies:
+// {"status": "...", "errors": [{"message": "..."}]}, {"errors": {"<field>": [{"message": "..."}]}} or
+// {"message": "..."}
+func bodyError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode:   resp.StatusCode,
+		authenticate: resp.Header.Get("WWW-Authenticate") != "",
+	}
+	if resp.Request != nil {
+		e.URL = resp.Request.URL.String()
+	}
+	var canvasErr struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Errors  json.RawMessage `json:"errors"`
+	}
+	if json.Unmarshal(body, &canvasErr) != nil {
+		return e
+	}
+	e.Status = canvasErr.Status
+	if canvasErr.Message != "" {
+		e.Messages = append(e.Messages, canvasErr.Message)
+	}
+	var status Status
+	if json.Unmarshal(body, &status) == nil {
+		for _, msg := range status.Errors {
+			e.Messages = append(e.Messages, msg.Message)
+		}
+		return e
+	}
+	var fields map[string][]struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(canvasErr.Errors, &fields) == nil {
+		for field, msgs := range fields {
+			for _, msg := range msgs {
+				e.Messages = append(e.Messages, field+": "+msg.Message)
+			}
+		}
+		sort.Strings(e.Messages)
+	}
+	return e
+}
@@ -0,0 +1,372 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExportFormat is a file format course content, such as pages, is exported in
+type ExportFormat string
+
+const (
+	// ExportHTML saves content as a standalone HTML document with a minimal stylesheet
+	ExportHTML ExportFormat = "html"
+	// ExportMarkdown saves content as Markdown with YAML front matter
+	ExportMarkdown ExportFormat = "md"
+	// ExportJSON saves content as Canvas described it, with links left pointing at Canvas
+	ExportJSON ExportFormat = "json"
+)
+
+// ParseExportFormats parses a comma separated list of export formats, e.g. html,md,json
+func ParseExportFormats(s string) ([]ExportFormat, error) {
+	var formats []ExportFormat
+	for _, name := range strings.Split(s, ",") {
+		switch f := ExportFormat(strings.ToLower(strings.TrimSpace(name))); f {
+		case "":
+		case ExportHTML, ExportMarkdown, ExportJSON:
+			formats = append(formats, f)
+		case "markdown":
+			formats = append(formats, ExportMarkdown)
+		default:
+			return nil, fmt.Errorf("unknown export format %q, expected html, md or json", name)
+		}
+	}
+	return formats, nil
+}
+
+// ExportOptions selects the formats each kind of course content is exported in, content with no
+// formats is not exported
+type ExportOptions struct {
+	Pages       []ExportFormat
+	Assignments []ExportFormat
+	// Discussions covers both discussion topics and announcements
+	Discussions []ExportFormat
+	// Filter selects which inline images are downloaded, as it does files, along with the ignore rules
+	Filter Filter
+}
+
+// Directories within each course's directory that each kind of content is exported to
+const (
+	pagesDir         = "pages"
+	assignmentsDir   = "assignments"
+	discussionsDir   = "discussions"
+	announcementsDir = "announcements"
+)
+
+// Exporter saves course content, such as pages, assignments and discussions, for reading offline below the
+// directory the output template places the course's files in. Content is collected while files are found and
+// written by Write once they have been downloaded, so links can point at the local copies. Each
+// piece of content is exported at most once, however many modules link to it.
+type Exporter struct {
+	r      Requester
+	output *PathTemplate
+	opts   ExportOptions
+
+	mu    sync.Mutex
+	docs  map[string]*document
+	order []*document
+	// courseDirs maps the IDs of courses to the directories their content is exported below, and
+	// dirCourses those directories back to the courses, so no two courses share one
+	courseDirs map[int]string
+	dirCourses map[string]int
+	// images maps the IDs of files downloaded for use as inline images to their paths, empty if they could not be
+	images map[int]string
+}
+
+// document is a piece of course content to be exported
+type document struct {
+	course Course
+	// kind is the directory within the course's directory the document is exported to, e.g. pagesDir
+	kind string
+	// id identifies the document among those of its kind in links, e.g. a page's URL slug
+	id string
+	// name is the file name the document is exported to, without extension
+	name    string
+	title   string
+	htmlURL string
+	// meta describes the document below its title and in its front matter
+	meta    []metaField
+	body    string
+	formats []ExportFormat
+	// data is what Canvas described the document with, written as JSON
+	data interface{}
+}
+
+// metaField is a named value describing a document. Values are strings, numbers, times or lists of strings.
+type metaField struct {
+	key   string
+	label string
+	value interface{}
+}
+
+// NewExporter returns an Exporter saving content in the formats selected by opts alongside the files
+// output lays out
+func NewExporter(r Requester, output *PathTemplate, opts ExportOptions) *Exporter {
+	return &Exporter{
+		r:          r,
+		output:     output,
+		opts:       opts,
+		docs:       make(map[string]*document),
+		courseDirs: make(map[int]string),
+		dirCourses: make(map[string]int),
+		images:     make(map[int]string),
+	}
+}
+
+// exports reports whether content of kind is exported
+func (e *Exporter) exports(kind string) bool {
+	if e == nil {
+		return false
+	}
+	switch kind {
+	case pagesDir:
+		return len(e.opts.Pages) > 0
+	case assignmentsDir:
+		return len(e.opts.Assignments) > 0
+	case discussionsDir, announcementsDir:
+		return len(e.opts.Discussions) > 0
+	}
+	return false
+}
+
+// add adds doc, unless a document of its kind and ID has already been added to its course
+func (e *Exporter) add(doc *document) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := docKey(doc.course.ID, doc.kind, doc.id)
+	if e.docs[key] != nil {
+		return
+	}
+	e.docs[key] = doc
+	e.order = append(e.order, doc)
+}
+
+// lookup returns the document of kind with id added to the course with ID courseID, or nil
+func (e *Exporter) lookup(courseID int, kind, id string) *document {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.docs[docKey(courseID, kind, id)]
+}
+
+func docKey(courseID int, kind, id string) string {
+	return strconv.Itoa(courseID) + "/" + kind + "/" + id
+}
+
+// Write saves everything added in its formats, pointing links at the local copies of the files
+// recorded in manifest and of the other exported content, and downloading inline images
+func (e *Exporter) Write(ctx context.Context, manifest *Manifest) error {
+	e.mu.Lock()
+	docs := append([]*document(nil), e.order...)
+	e.mu.Unlock()
+	var failed []error
+	for _, doc := range docs {
+		if err := e.write(ctx, manifest, doc); err != nil {
+			failed = append(failed, fmt.Errorf("%s: exporting %s: %w", doc.course.Name, doc.title, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d pieces of course content could not be exported, the first: %w", len(failed), len(docs), failed[0])
+	}
+	return nil
+}
+
+// write saves doc in each of its formats
+func (e *Exporter) write(ctx context.Context, manifest *Manifest, doc *document) error {
+	unresolved := 0
+	for _, format := range doc.formats {
+		path := e.docPath(doc, format)
+		if format == ExportJSON {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			err := enc.Encode(doc.data)
+			if err == nil {
+				err = writeFileAtomic(path, buf.Bytes())
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		body, n, err := e.rewriteLinks(ctx, manifest, doc, filepath.Dir(path), format)
+		if err != nil {
+			return err
+		}
+		unresolved = n
+		var data []byte
+		switch format {
+		case ExportHTML:
+			data, err = renderHTML(doc, body)
+		case ExportMarkdown:
+			data, err = renderMarkdown(doc, body)
+		}
+		if err == nil {
+			err = writeFileAtomic(path, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	e.r.Log.Info("Exported "+strings.TrimSuffix(doc.kind, "s"), "course", doc.course.Name, "title", doc.title,
+		"path", e.docPath(doc, doc.formats[0]), "unresolved_links", unresolved)
+	return nil
+}
+
+// courseDir returns the directory course's content is exported below: the directory its files are
+// downloaded to, or a directory named after the course within it if that is the output directory
+// itself or is shared with another course, suffixed with the course's ID if that is taken too
+func (e *Exporter) courseDir(course Course) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if dir, ok := e.courseDirs[course.ID]; ok {
+		return dir
+	}
+	dir, err := e.output.courseDir(course)
+	if err != nil {
+		e.r.Log.Warn("Could not place course content with its files", "course", course.Name, "error", err)
+		dir = e.output.Root()
+	}
+	if id, taken := e.dirCourses[dir]; dir == e.output.Root() || taken && id != course.ID {
+		dir = filepath.Join(dir, strings.ReplaceAll(SanitiseName(course.Name), " ", ""))
+	}
+	if id, taken := e.dirCourses[dir]; taken && id != course.ID {
+		dir += "-" + strconv.Itoa(course.ID)
+	}
+	e.courseDirs[course.ID] = dir
+	e.dirCourses[dir] = course.ID
+	return dir
+}
+
+// docPath returns the path doc is exported to in format
+func (e *Exporter) docPath(doc *document, format ExportFormat) string {
+	return filepath.Join(e.courseDir(doc.course), doc.kind, SanitiseName(doc.name)+"."+string(format))
+}
+
+// stylesheet keeps exported content readable without any of Canvas' styles
+const stylesheet = `body{max-width:50em;margin:2em auto;padding:0 1em;font-family:sans-serif;line-height:1.5;color:#222}
+header{border-bottom:1px solid #ddd;margin-bottom:1.5em}header dl{color:#666;font-size:.9em}
+header dt{float:left;clear:left;margin-right:.5em}header dt:after{content:":"}header dd{margin:0}
+img{max-width:100%;height:auto}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:.3em .6em}
+pre{background:#f6f6f6;padding:.8em;overflow:auto}blockquote{border-left:3px solid #ddd;margin-left:0;padding-left:1em;color:#555}
+.canvas-unresolved-marker{color:#a00;font-size:.85em}`
+
+var docTemplate = template.Must(template.New("document").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+{{if .HTMLURL}}<meta name="canvas-url" content="{{.HTMLURL}}">
+{{end}}{{range .Meta}}<meta name="{{.Key}}" content="{{.Value}}">
+{{end}}<style>{{.Stylesheet}}</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+{{if .Meta}}<dl>
+{{range .Meta}}<dt>{{.Label}}</dt><dd>{{if .Time}}<time datetime="{{.Value}}">{{.Text}}</time>{{else}}{{.Text}}{{end}}</dd>
+{{end}}</dl>
+{{end}}</header>
+<main>
+{{.Body}}
+</main>
+</body>
+</html>
+`))
+
+// renderHTML renders doc, with body in place of its own, as a standalone HTML document
+func renderHTML(doc *document, body string) ([]byte, error) {
+	type meta struct {
+		Key, Label, Value, Text string
+		Time                    bool
+	}
+	data := struct {
+		Title      string
+		HTMLURL    string
+		Meta       []meta
+		Stylesheet template.CSS
+		// Body is Canvas' own sanitised HTML
+		Body template.HTML
+	}{
+		Title:      doc.title,
+		HTMLURL:    doc.htmlURL,
+		Stylesheet: stylesheet,
+		Body:       template.HTML(body),
+	}
+	for _, f := range doc.meta {
+		_, isTime := f.value.(time.Time)
+		data.Meta = append(data.Meta, meta{Key: strings.ReplaceAll(f.key, "_", "-"), Label: f.label,
+			Value: metaValue(f.value), Text: metaText(f.value), Time: isTime})
+	}
+	var buf bytes.Buffer
+	err := docTemplate.Execute(&buf, data)
+	return buf.Bytes(), err
+}
+
+// renderMarkdown renders doc, with body in place of its own, as Markdown with its title, Canvas URL
+// and meta as YAML front matter
+func renderMarkdown(doc *document, body string) ([]byte, error) {
+	markdown, err := HTMLToMarkdown(body)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	fmt.Fprintf(&buf, "title: %s\n", strconv.Quote(doc.title))
+	for _, f := range doc.meta {
+		fmt.Fprintf(&buf, "%s: %s\n", f.key, yamlValue(f.value))
+	}
+	if doc.htmlURL != "" {
+		fmt.Fprintf(&buf, "canvas_url: %s\n", strconv.Quote(doc.htmlURL))
+	}
+	buf.WriteString("---\n\n# " + markdownEscaper.Replace(doc.title) + "\n\n" + markdown + "\n")
+	return buf.Bytes(), nil
+}
+
+// metaValue formats v for machines, in meta tags
+func metaValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// metaText formats v for people
+func metaText(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format("2 January 2006 15:04")
+	case []string:
+		return strings.Join(v, ", ")
+	}
+	return metaValue(v)
+}
+
+// yamlValue formats v as a YAML value
+func yamlValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time, float64, int:
+		return metaValue(v)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter selects which files are downloaded by their size, last update and MIME class.
+// The zero Filter matches every file.
+type Filter struct {
+	// MinSize and MaxSize bound File.Size in bytes, zero means unbounded
+	MinSize int64
+	MaxSize int64
+	// Since and Until bound File.UpdatedAt, the zero time means unbounded
+	Since time.Time
+	Until time.Time
+	// MimeClasses lists the File.MimeClass values to download, empty means any
+	MimeClasses []string
+}
+
+// FilterSpec holds the textual form of a Filter, as given on the command line or in the config file
+type FilterSpec struct {
+	MinSize   string
+	MaxSize   string
+	Since     string
+	Until     string
+	MimeClass string
+}
+
+// ParseFilter parses spec into a Filter. Sizes take the same form as in .scrapeignore, e.g. 50MB,
+// times are dates (2006-01-02, inclusive for Until), RFC 3339 timestamps or ages relative to now (36h, 14d, 2w), and
+// MIME classes are separated by commas.
+func ParseFilter(spec FilterSpec) (Filter, error) {
+	var f Filter
+	var err error
+	if spec.MinSize != "" {
+		if f.MinSize, err = ParseSize(spec.MinSize); err != nil {
+			return Filter{}, fmt.Errorf("min size: %w", err)
+		}
+	}
+	if spec.MaxSize != "" {
+		if f.MaxSize, err = ParseSize(spec.MaxSize); err != nil {
+			return Filter{}, fmt.Errorf("max size: %w", err)
+		}
+	}
+	if spec.Since != "" {
+		if f.Since, err = ParseTime(spec.Since, time.Now()); err != nil {
+			return Filter{}, fmt.Errorf("since: %w", err)
+		}
+	}
+	if spec.Until != "" {
+		if f.Until, err = ParseTime(spec.Until, time.Now()); err != nil {
+			return Filter{}, fmt.Errorf("until: %w", err)
+		}
+		// a date on its own includes the whole of that day
+		if _, err := time.Parse("2006-01-02", spec.Until); err == nil {
+			f.Until = f.Until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	for _, class := range strings.Split(spec.MimeClass, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			f.MimeClasses = append(f.MimeClasses, strings.ToLower(class))
+		}
+	}
+	return f, nil
+}
+
+// ParseTime parses s as a date, an RFC 3339 timestamp or an age such as 36h, 14d or 2w before now
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			n, err := strconv.Atoi(s[:len(s)-1])
+			if err == nil && n >= 0 {
+				return now.Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date, RFC 3339 timestamp or age such as 14d", s)
+}
+
+// Match reports whether file passes every bound of the filter
+func (f Filter) Match(file File) bool {
+	size := int64(file.Size)
+	switch {
+	case f.MinSize > 0 && size < f.MinSize,
+		f.MaxSize > 0 && size > f.MaxSize,
+		!f.Since.IsZero() && file.UpdatedAt.Before(f.Since),
+		!f.Until.IsZero() && file.UpdatedAt.After(f.Until):
+		return false
+	}
+	if len(f.MimeClasses) == 0 {
+		return true
+	}
+	for _, class := range f.MimeClasses {
+		if strings.EqualFold(class, file.MimeClass) {
+			return true
+		}
+	}
+	return false
+}
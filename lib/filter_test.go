@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{"2026-09-01T08:30:00Z", time.Date(2026, 9, 1, 8, 30, 0, 0, time.UTC), false},
+		{"2026-09-01", time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local), false},
+		{"36h", now.Add(-36 * time.Hour), false},
+		{"14d", now.AddDate(0, 0, -14), false},
+		{"2w", now.AddDate(0, 0, -14), false},
+		{"0d", now, false},
+		{"", time.Time{}, true},
+		{"d", time.Time{}, true},
+		{"-3d", time.Time{}, true},
+		{"3y", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in, now)
+		if (err != nil) != tt.err || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	since := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	f := Filter{MinSize: 10, MaxSize: 100, Since: since, MimeClasses: []string{"pdf", "doc"}}
+	tests := []struct {
+		name string
+		file File
+		want bool
+	}{
+		{"within every bound", File{Size: 50, UpdatedAt: since, MimeClass: "PDF"}, true},
+		{"too small", File{Size: 9, UpdatedAt: since, MimeClass: "pdf"}, false},
+		{"too large", File{Size: 101, UpdatedAt: since, MimeClass: "pdf"}, false},
+		{"too old", File{Size: 50, UpdatedAt: since.Add(-time.Second), MimeClass: "pdf"}, false},
+		{"other class", File{Size: 50, UpdatedAt: since, MimeClass: "video"}, false},
+	}
+	for _, tt := range tests {
+		if got := f.Match(tt.file); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !(Filter{}).Match(File{Size: 1 << 30}) {
+		t.Error("the zero Filter excluded a file")
+	}
+}
+
+func TestParseFilterUntil(t *testing.T) {
+	day := time.Date(2026, 9, 30, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		until   string
+		updated time.Time
+		want    bool
+	}{
+		{"2026-09-30", day, true},
+		{"2026-09-30", day.Add(23*time.Hour + 59*time.Minute), true},
+		{"2026-09-30", day.AddDate(0, 0, 1), false},
+		{"2026-09-30T12:00:00Z", time.Date(2026, 9, 30, 12, 0, 0, 0, time.UTC), true},
+		{"2026-09-30T12:00:00Z", time.Date(2026, 9, 30, 12, 0, 1, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(FilterSpec{Until: tt.until})
+		if err != nil {
+			t.Fatalf("ParseFilter(until %q): %v", tt.until, err)
+		}
+		if got := f.Match(File{UpdatedAt: tt.updated}); got != tt.want {
+			t.Errorf("until %q: Match(updated %v) = %v, want %v", tt.until, tt.updated, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ignoreDir holds per-course ignore files, named after the course's ID, code or name without spaces,
+// ignoring case
+const ignoreDir = ".scrapeignore.d"
+
+// Ignore decides which files are skipped before anything is downloaded, following .gitignore semantics:
+// blank lines and lines starting with # are skipped, ! negates a rule, and the last matching rule wins.
+//
+// Glob rules are matched against "<course>/<module>/<filename>", with the module left out for files
+// found outside of modules. A rule without a slash matches any element of that path, a rule containing
+// one is anchored to the course, and ** matches any number of elements. A bare word such as "mp4" also
+// matches it as an extension, like "*.mp4", as .scrapeignore used to list extensions, while still
+// matching modules and files named after it, such as "Solutions" or "Makefile".
+//
+// Rules can also match on file attributes: "mime:<glob>" on MimeClass, "content-type:<glob>" on
+// ContentType and "size>N", "size>=N", "size<N" or "size<=N" on Size, where N may end in KB, MB or GB.
+type Ignore struct {
+	global []ignoreRule
+	dir    string
+	log    *Logger
+
+	mu      sync.Mutex
+	courses map[int][]ignoreRule
+}
+
+type ignoreRule struct {
+	negate bool
+	match  func(subject string, file File) bool
+}
+
+var extensionRule = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// sizeRule matches the start of a size rule, other lines starting with "size", such as sizes/*, are globs
+var sizeRule = regexp.MustCompile(`^size[<>]`)
+
+// LoadIgnore reads the rules in the ignore file at path, and per-course rules from the .scrapeignore.d
+// directory beside it. A missing file or directory means nothing is ignored.
+func LoadIgnore(path string) (*Ignore, error) {
+	ignore := &Ignore{dir: filepath.Join(filepath.Dir(path), ignoreDir), courses: make(map[int][]ignoreRule)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ignore, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ignore.global, err = parseIgnore(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ignore, nil
+}
+
+// Len returns the number of global rules
+func (ig *Ignore) Len() int {
+	if ig == nil {
+		return 0
+	}
+	return len(ig.global)
+}
+
+// Ignored reports whether file, found through source, should be skipped
+func (ig *Ignore) Ignored(source FileSource, file File) bool {
+	if ig == nil {
+		return false
+	}
+	subject := source.Course.Name + "/" + file.Filename
+	if source.Module.Name != "" {
+		subject = source.Course.Name + "/" + source.Module.Name + "/" + file.Filename
+	}
+	ignored := false
+	for _, rules := range [][]ignoreRule{ig.global, ig.courseRules(source.Course)} {
+		for _, rule := range rules {
+			if rule.match(subject, file) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// courseRules returns the rules from course's own ignore file, reading it the first time it is needed
+func (ig *Ignore) courseRules(course Course) []ignoreRule {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	if rules, ok := ig.courses[course.ID]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	names := map[string]bool{
+		strconv.Itoa(course.ID):            true,
+		strings.ToLower(course.CourseCode): true,
+		compactName(course.Name):           true,
+	}
+	entries, _ := ioutil.ReadDir(ig.dir)
+	for _, entry := range entries {
+		if entry.IsDir() || !names[strings.ToLower(entry.Name())] {
+			continue
+		}
+		f, err := os.Open(filepath.Join(ig.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		parsed, err := parseIgnore(f)
+		f.Close()
+		if err != nil {
+			ig.log.Warn("Ignoring invalid ignore rules", "course", course.Name, "file", entry.Name(), "error", err)
+			continue
+		}
+		rules = append(rules, parsed...)
+	}
+	ig.courses[course.ID] = rules
+	return rules
+}
+
+// parseIgnore reads one rule per line from r
+func parseIgnore(r io.Reader) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(strings.TrimSuffix(scanner.Text(), "\r"), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		match, err := parseIgnoreMatcher(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rule.match = match
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func parseIgnoreMatcher(line string) (func(string, File) bool, error) {
+	switch {
+	case strings.HasPrefix(line, "mime:"):
+		pattern := strings.TrimPrefix(line, "mime:")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(_ string, file File) bool {
+			ok, _ := path.Match(pattern, file.MimeClass)
+			return ok
+		}, nil
+	case strings.HasPrefix(line, "content-type:"):
+		pattern := strings.TrimPrefix(line, "content-type:")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(_ string, file File) bool {
+			ok, _ := path.Match(pattern, strings.SplitN(file.ContentType, ";", 2)[0])
+			return ok
+		}, nil
+	case sizeRule.MatchString(line):
+		return parseSizeRule(strings.TrimPrefix(line, "size"))
+	}
+	re, err := globRegexp(line)
+	if err != nil {
+		return nil, err
+	}
+	if extensionRule.MatchString(line) {
+		ext, err := globRegexp("*." + line)
+		if err != nil {
+			return nil, err
+		}
+		return func(subject string, _ File) bool {
+			return re.MatchString(subject) || ext.MatchString(subject)
+		}, nil
+	}
+	return func(subject string, _ File) bool {
+		return re.MatchString(subject)
+	}, nil
+}
+
+// parseSizeRule parses the comparison following "size" in a rule, e.g. ">100MB"
+func parseSizeRule(rule string) (func(string, File) bool, error) {
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if !strings.HasPrefix(rule, op) {
+			continue
+		}
+		limit, err := ParseSize(strings.TrimSpace(strings.TrimPrefix(rule, op)))
+		if err != nil {
+			return nil, err
+		}
+		return func(_ string, file File) bool {
+			size := int64(file.Size)
+			switch op {
+			case ">=":
+				return size >= limit
+			case "<=":
+				return size <= limit
+			case ">":
+				return size > limit
+			}
+			return size < limit
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid size rule %q", "size"+rule)
+}
+
+// ParseSize parses a size in bytes with an optional B, KB, MB or GB suffix, where a KB is 1024 bytes
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, multiplier = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix)), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// globRegexp compiles a gitignore style glob into a regular expression matching the path of a file
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	dirOnly := strings.HasSuffix(glob, "/")
+	glob = strings.TrimSuffix(glob, "/")
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	var re strings.Builder
+	if anchored {
+		re.WriteString("^")
+	} else {
+		re.WriteString("(^|/)")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				re.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// a pattern naming a directory ignores everything below it
+	if dirOnly {
+		re.WriteString("/.*$")
+	} else {
+		re.WriteString("(/.*)?$")
+	}
+	return regexp.Compile(re.String())
+}
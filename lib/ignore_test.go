@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	rules := `# comment
+mp4
+Solutions
+Makefile
+*.tmp
+slides/
+Intro/Week1/draft-*.pdf
+**/solutions/**
+!keep.tmp
+mime:video
+content-type:application/zip
+size>=100MB
+sizeguide.pdf
+sizes/*
+\#hash.txt
+`
+	parsed, err := parseIgnore(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ig := &Ignore{global: parsed, courses: make(map[int][]ignoreRule)}
+	course := Course{ID: 1, Name: "Intro"}
+	week1 := Module{ID: 1, Name: "Week1"}
+	tests := []struct {
+		name   string
+		module Module
+		file   File
+		want   bool
+	}{
+		{"extension", Module{}, File{Filename: "lecture.mp4"}, true},
+		{"extension is not a substring", Module{}, File{Filename: "mp4notes.pdf"}, false},
+		{"bare word as a module", Module{ID: 2, Name: "Solutions"}, File{Filename: "answers.pdf"}, true},
+		{"bare word as a file", Module{}, File{Filename: "Makefile"}, true},
+		{"bare word as an extension", Module{}, File{Filename: "build.Makefile"}, true},
+		{"bare word within a name", Module{}, File{Filename: "Solutions.pdf"}, false},
+		{"glob", week1, File{Filename: "a.tmp"}, true},
+		{"negated", week1, File{Filename: "keep.tmp"}, false},
+		{"directory", week1, File{Filename: "slides"}, false},
+		{"anchored", week1, File{Filename: "draft-1.pdf"}, true},
+		{"anchored outside its module", Module{}, File{Filename: "draft-1.pdf"}, false},
+		{"mime", Module{}, File{Filename: "a.mov", MimeClass: "video"}, true},
+		{"content type", Module{}, File{Filename: "a", ContentType: "application/zip; charset=binary"}, true},
+		{"size", Module{}, File{Filename: "big.pdf", Size: 100 << 20}, true},
+		{"under size", Module{}, File{Filename: "small.pdf", Size: 100<<20 - 1}, false},
+		{"glob starting with size", Module{}, File{Filename: "sizeguide.pdf"}, true},
+		{"escaped hash", Module{}, File{Filename: "#hash.txt"}, true},
+		{"nothing matches", week1, File{Filename: "notes.pdf"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ig.Ignored(FileSource{Course: course, Module: tt.module}, tt.file); got != tt.want {
+				t.Errorf("Ignored(%s) = %v, want %v", tt.file.Filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnoreErrors(t *testing.T) {
+	for _, rule := range []string{"size>lots", "size<", "[abc", "mime:[", "content-type:["} {
+		if _, err := parseIgnore(strings.NewReader("ok\n" + rule + "\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("parseIgnore(%q) = %v, want an error on line 2", rule, err)
+		}
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		subject string
+		want    bool
+	}{
+		{"*.pdf", "Intro/Week1/notes.pdf", true},
+		{"*.pdf", "Intro/notes.pdf.bak", false},
+		{"notes?.pdf", "Intro/notes1.pdf", true},
+		{"notes?.pdf", "Intro/notes10.pdf", false},
+		{"Week1", "Intro/Week1/notes.pdf", true},
+		{"Intro/*.pdf", "Intro/notes.pdf", true},
+		{"Intro/*.pdf", "Intro/Week1/notes.pdf", false},
+		{"/Intro/**/notes.pdf", "Intro/Week1/notes.pdf", true},
+		{"Intro/**/notes.pdf", "Intro/notes.pdf", true},
+		{"Intro/**", "Intro/Week1/notes.pdf", true},
+		{"Week1/", "Intro/Week1/notes.pdf", true},
+		{"Week1/", "Intro/Week1", false},
+		{"[a-c]*.pdf", "Intro/b.pdf", true},
+		{"[!a-c]*.pdf", "Intro/b.pdf", false},
+		{`\*.pdf`, "Intro/*.pdf", true},
+		{`\*.pdf`, "Intro/a.pdf", false},
+		{"a+b(1).pdf", "Intro/a+b(1).pdf", true},
+	}
+	for _, tt := range tests {
+		re, err := globRegexp(tt.glob)
+		if err != nil {
+			t.Errorf("globRegexp(%q): %v", tt.glob, err)
+			continue
+		}
+		if got := re.MatchString(tt.subject); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.glob, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"2KB", 2048, false},
+		{"1.5MB", 3 << 19, false},
+		{"1gb", 1 << 30, false},
+		{" 10 M ", 10 << 20, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1KB", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Layout selects how downloaded files are arranged beneath their course's directory
+type Layout string
+
+const (
+	// LayoutFlat puts every file directly in the course directory
+	LayoutFlat Layout = "flat"
+	// LayoutFolders mirrors the folder tree of the course's files area
+	LayoutFolders Layout = "folders"
+	// LayoutModules puts files found in a module into a directory named after the module and its position
+	LayoutModules Layout = "modules"
+)
+
+// ParseLayout returns the Layout named s
+func ParseLayout(s string) (Layout, error) {
+	switch l := Layout(strings.ToLower(s)); l {
+	case LayoutFlat, LayoutFolders, LayoutModules:
+		return l, nil
+	case "":
+		return LayoutFlat, nil
+	}
+	return "", fmt.Errorf("unknown layout %q, expected flat, folders or modules", s)
+}
+
+// CourseFolder is a folder in a course's files area
+type CourseFolder struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	FullName       string `json:"full_name"`
+	ParentFolderID int    `json:"parent_folder_id"`
+	Position       int    `json:"position"`
+	FilesCount     int    `json:"files_count"`
+	FoldersCount   int    `json:"folders_count"`
+	Hidden         bool   `json:"hidden"`
+	Locked         bool   `json:"locked"`
+}
+
+// RelativePath returns the folder's path below the root of the course's files area
+func (folder CourseFolder) RelativePath() string {
+	// full_name always starts with the root folder, "course files"
+	parts := strings.SplitN(folder.FullName, "/", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// GetCourseFolders lists every folder in course's files area
+func (course *Course) GetCourseFolders(ctx context.Context, r Requester) ([]CourseFolder, error) {
+	return r.ListFolders(ctx, course.ID)
+}
+
+// folderTree caches the folder paths of each course's files area, fetching them when first needed
+type folderTree struct {
+	mu      sync.Mutex
+	courses map[int]map[int]string
+}
+
+// path returns the path of the folder with the given ID in course. If the course's folders may not
+// be listed, as happens when its Files tab is hidden, every path is the root of its files area.
+// A rejected access token is returned, as it stops every other request too.
+func (t *folderTree) path(ctx context.Context, r Requester, course Course, id int) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.courses == nil {
+		t.courses = make(map[int]map[int]string)
+	}
+	paths, ok := t.courses[course.ID]
+	if !ok {
+		folders, err := course.GetCourseFolders(ctx, r)
+		if errors.Is(err, ErrForbidden) || errors.Is(err, ErrNotFound) {
+			r.Log.Warn("Course folders not available, placing its files in the course directory",
+				"course", course.Name, "course_id", course.ID, "error", err)
+			t.courses[course.ID] = map[int]string{}
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("listing folders: %w", err)
+		}
+		paths = make(map[int]string, len(folders))
+		for _, folder := range folders {
+			paths[folder.ID] = folder.RelativePath()
+		}
+		t.courses[course.ID] = paths
+	}
+	return paths[id], nil
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFolderTreeUnavailable(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		header  string
+		wantErr error
+	}{
+		{"files tab hidden", http.StatusForbidden, "", nil},
+		{"not found", http.StatusNotFound, "", nil},
+		{"token rejected", http.StatusUnauthorized, `Bearer realm="canvas-lms"`, ErrAuth},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.header != "" {
+				w.Header().Set("WWW-Authenticate", tt.header)
+			}
+			w.WriteHeader(tt.status)
+		}))
+		var tree folderTree
+		path, err := tree.path(context.Background(), testRequester(srv), Course{ID: 1, Name: "Intro"}, 7)
+		srv.Close()
+		if !errors.Is(err, tt.wantErr) || path != "" {
+			t.Errorf("%s: path() = %q, %v, want \"\", %v", tt.name, path, err, tt.wantErr)
+		}
+	}
+}
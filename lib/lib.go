@@ -1,17 +1,15 @@
 package lib
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/spf13/viper"
 	"io"
-	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
 	"os"
-    "regexp"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -38,6 +36,7 @@ type Page struct {
 	LockedForUser    bool        `json:"locked_for_user"`
 	Body             string      `json:"body"`
 }
+
 // File contains information relating to an individual file on canvas
 type File struct {
 	ID                 int         `json:"id"`
@@ -80,10 +79,14 @@ type Folder struct {
 
 // Requester is a structure used in the http request to contain related data
 type Requester struct {
-	Context string
-	Headers map[string]string
-	BaseURL string
-	Ignore  []string
+	// Client sends every request to the Canvas instance
+	*Client
+	// Ignore decides which files are skipped, it ignores nothing if nil
+	Ignore *Ignore
+	// Instance is the name of the configured Canvas instance, empty if none was selected
+	Instance string
+	// Log receives progress and diagnostic events, nothing is logged if nil
+	Log *Logger
 }
 
 // Status returned instead of structured response
@@ -110,7 +113,7 @@ type Course struct {
 	EnrollmentTermID            int         `json:"enrollment_term_id"`
 	License                     string      `json:"license"`
 	GradePassbackSetting        interface{} `json:"grade_passback_setting"`
-	EndAt                       interface{} `json:"end_at"`
+	EndAt                       time.Time   `json:"end_at"`
 	PublicSyllabus              bool        `json:"public_syllabus"`
 	PublicSyllabusToAuth        bool        `json:"public_syllabus_to_auth"`
 	StorageQuotaMb              int         `json:"storage_quota_mb"`
@@ -119,8 +122,15 @@ type Course struct {
 	Calendar                    struct {
 		Ics string `json:"ics"`
 	} `json:"calendar"`
-	TimeZone    string `json:"time_zone"`
-	Blueprint   bool   `json:"blueprint"`
+	TimeZone  string `json:"time_zone"`
+	Blueprint bool   `json:"blueprint"`
+	// Term is only populated when courses are listed with include[]=term
+	Term struct {
+		ID      int       `json:"id"`
+		Name    string    `json:"name"`
+		StartAt time.Time `json:"start_at"`
+		EndAt   time.Time `json:"end_at"`
+	} `json:"term"`
 	Enrollments []struct {
 		Type                           string `json:"type"`
 		Role                           string `json:"role"`
@@ -167,150 +177,174 @@ func (e *NoFilesError) Error() string {
 	return fmt.Sprintf("course, %s, does not seem to have any files publicly available\n", strings.ReplaceAll(e.Course, " ", ""))
 }
 
-func GetCourses(r Requester, spec []string) ([]Course, error) {
-	if len(r.Headers) == 0 {
-		return nil, errors.New("empty headers")
-	}
-	req, err := http.NewRequest("GET", "https://"+r.BaseURL+r.Context, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Authorization", r.Headers["Authorization"])
-	println("https://" + r.BaseURL + r.Context)
-	println("Executing request")
-	resp, err := http.DefaultClient.Do(req)
-	println("Executed.")
-	if err != nil {
-		println("Error in request")
-		return nil, err
-	}
-	defer resp.Body.Close()
-	courses := make([]Course, 0)
-	println("Reading Courses")
-	body, err := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &courses)
-
-	if err != nil {
-		fmt.Printf("%s, \n", body)
-		return nil, err
+// GetCourses lists the courses you are enrolled in which are picked by selection
+func GetCourses(ctx context.Context, r Requester, selection CourseSelection) ([]Course, error) {
+	if r.Client == nil {
+		return nil, errors.New("no client")
 	}
-	err = resp.Body.Close()
+	r.Log.Debug("Listing courses", "base_url", r.BaseURL)
+	courses, err := r.ListCourses(ctx)
 	if err != nil {
 		return nil, err
 	}
 	ret := make([]Course, 0)
-	if len(spec) > 0 {
-		println("filtering discovered courses")
-		for _, course := range courses {
-			for _, specifiedCourse := range spec {
-				if strings.ToLower(strings.ReplaceAll(course.Name, " ", "")) == strings.ToLower(specifiedCourse) {
-					ret = append(ret, course)
-				}
-			}
+	now := time.Now()
+	for _, course := range courses {
+		if selection.Match(course, now) {
+			ret = append(ret, course)
 		}
-	} else {
-		ret = courses
 	}
+	r.Log.Debug("Selected courses", "enrolled", len(courses), "selected", len(ret))
 	return ret, nil
 }
 
-// Download downloads files to a given filepath from a given URL using data in a Requester Struct
-func (file *File) Download(course Course, r Requester) {
+// Download downloads files to a given filepath from a given URL using data in a Requester Struct,
+// returning the number of bytes written. The body is written to a partial file alongside the
+// destination which is only renamed into place once complete, so an interrupted download never
+// leaves a truncated file behind. An interrupted download is resumed with a Range request next
+// time, provided the remote file has not changed in the meantime. Cancelling ctx aborts the download,
+// keeping the partial file to be resumed.
+func (file *File) Download(ctx context.Context, dest string, r Requester) (int64, error) {
+	return file.download(ctx, dest, r, nil)
+}
+
+// download is Download, calling progress, if not nil, with the number of bytes written as the body is copied
+func (file *File) download(ctx context.Context, dest string, r Requester, progress func(n int64)) (int64, error) {
 	if file.URL == "" {
-		log.Println(errors.New("no file URL"))
-		return
-	}
-	filepath := strings.ReplaceAll("out/"+course.Name+"/"+file.Filename, " ", "")
-	tmp := strings.Split(filepath, ".")
-	fileExt := tmp[len(tmp)-1]
-	for _, ext := range r.Ignore {
-		if ext == fileExt {
-			return
-		}
+		return 0, errors.New("no file URL")
+	}
+	err := os.MkdirAll(filepath.Dir(dest), 0777)
+	if err != nil {
+		return 0, err
 	}
+	part, metaPath := partPaths(dest, *file)
+	offset, etag := resumeOffset(*file, part, metaPath)
 	// Get the data
-	req, err := http.NewRequest("GET", file.URL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", file.URL, nil)
 	if err != nil {
-		log.Println(err)
-		return
+		return 0, err
 	}
-	req.Header.Add("Authorization", r.Headers["Authorization"])
-	resp, err := http.DefaultClient.Do(req)
+	if offset > 0 {
+		req.Header.Set("Range", rangeHeader(offset))
+		if etag != "" {
+			req.Header.Set("If-Range", etag)
+		}
+	}
+	resp, err := r.Do(req)
 	if err != nil {
-		log.Println(err)
-		return
+		return 0, err
 	}
 
 	defer resp.Body.Close()
 
-	// Create the file
-	out, err := os.Create(filepath)
-
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resumed(resp, offset):
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// the server ignored the range or the file changed, start over
+		offset = 0
+		flags |= os.O_TRUNC
+		etag = resp.Header.Get("ETag")
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		removePart(part, metaPath)
+		resp.Body.Close()
+		return file.download(ctx, dest, r, progress)
+	default:
+		return 0, responseError(resp)
+	}
+	if got := resp.Header.Get("Content-Type"); contradicts(got, file.ContentType) {
+		return 0, &ContentTypeError{URL: file.URL, Expected: file.ContentType, Got: got}
+	}
+
+	// Keep the partial file next to the destination so the rename cannot cross filesystems
+	err = writePartMeta(*file, etag, metaPath)
 	if err != nil {
-		log.Println(err)
-		return
+		return 0, err
 	}
-	defer out.Close()
-
-	// // Write the body to file
-
-	_, err = io.Copy(out, resp.Body)
-	err = resp.Body.Close()
+	out, err := os.OpenFile(part, flags, 0644)
 	if err != nil {
-		log.Println(err)
-		return
+		return 0, err
 	}
-	err = out.Close()
-	if err != nil {
-		log.Println(err)
-		return
+	var body io.Reader = resp.Body
+	if progress != nil {
+		body = &progressReader{r: body, progress: progress}
+	}
+	n, err := writePart(out, body, int64(file.Size)-offset)
+	if err != nil && ctx.Err() != nil {
+		// report the cancellation rather than the failed read it caused
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = os.Rename(part, dest)
+	}
+	if err == nil || offset+n > int64(file.Size) {
+		// finished, or the partial file is longer than the file itself and cannot be resumed
+		removePart(part, metaPath)
 	}
-	return
+	return n, err
 }
 
-func (course *Course) GetFiles(r Requester) error {
-	fmt.Printf("Looking for files in course, %s \n", course.Name)
-	var unmarshalTypeError *json.UnmarshalTypeError
-	if outputDir == "" {
-		_ = os.MkdirAll("out/"+strings.ReplaceAll(course.Name, " ", ""), 0777)
-	} else {
-		_ = os.MkdirAll(outputDir+"/"+strings.ReplaceAll(course.Name, " ", ""), 0777)
+// writePart copies body into out, checks the number of bytes written against size if it is known,
+// then flushes out to disk and closes it
+func writePart(out *os.File, body io.Reader, size int64) (int64, error) {
+	n, err := io.Copy(out, body)
+	if err == nil && size > 0 && n != size {
+		err = fmt.Errorf("incomplete download, received %d of %d bytes", n, size)
 	}
-	req, err := http.NewRequest("GET", "https://"+r.BaseURL+r.Context+strconv.Itoa(course.ID)+"/files/?per_page=1000", nil)
-	if err != nil {
-		return err
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
 	}
-	files := make([]File, 0)
+	return n, err
+}
 
-	req.Header.Add("Authorization", r.Headers["Authorization"])
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+// progressReader reports the number of bytes read from r to progress
+type progressReader struct {
+	r        io.Reader
+	progress func(n int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress(int64(n))
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	return n, err
+}
+
+// genericTypes are content types servers send for any file, which never contradict the type Canvas recorded
+var genericTypes = map[string]bool{
+	"application/octet-stream":   true,
+	"binary/octet-stream":        true,
+	"application/download":       true,
+	"application/force-download": true,
+}
+
+// contradicts reports whether the content type a server sent contradicts the content type Canvas recorded
+// for a file. HTML and JSON, which Canvas sends for error and login pages, contradict any other type,
+// otherwise only types of a different kind do, such as image/png for application/pdf.
+func contradicts(got, expected string) bool {
+	gotType, _, _ := mime.ParseMediaType(got)
+	expectedType, _, _ := mime.ParseMediaType(expected)
+	if gotType == "" || expectedType == "" || gotType == expectedType || genericTypes[gotType] || genericTypes[expectedType] {
+		return false
 	}
-	err = json.Unmarshal(body, &files)
-	if err != nil {
-		switch {
-		case errors.As(err, &unmarshalTypeError):
-			status := Status{}
-			e2 := json.Unmarshal(body, &status)
-			if e2 != nil {
-				println("Cannot unmarshal response")
-				return e2
-			} else {
-				if status.Status == "unauthorised" {
-					return &NoFilesError{course.Name}
-				}
-			}
-		}
-		return err
+	if gotType == "text/html" || gotType == "application/json" {
+		return true
 	}
-	err = resp.Body.Close()
+	return strings.SplitN(gotType, "/", 2)[0] != strings.SplitN(expectedType, "/", 2)[0]
+}
+
+// GetFiles schedules every file in course's files area for download
+func (course *Course) GetFiles(ctx context.Context, r Requester, s *Scheduler) error {
+	files, err := r.ListFiles(ctx, course.ID)
 	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return &NoFilesError{course.Name}
+		}
 		return err
 	}
 	if len(files) == 0 {
@@ -318,46 +352,19 @@ func (course *Course) GetFiles(r Requester) error {
 	}
 
 	for _, file := range files {
-		var filename string
-		if outputDir == "" {
-			filename = strings.ReplaceAll("out/"+course.Name+"/"+file.Filename, " ", "")
-		} else {
-			filename = strings.ReplaceAll(outputDir+"/"+course.Name+"/"+file.Filename, " ", "")
-		}
-		_, err = os.Stat(filename)
-		if forceDownloadAll || os.IsNotExist(err) {
-			file.Download(*course, r)
-		}
+		s.Add(FileSource{Course: *course}, file)
 	}
 
 	return nil
 }
 
-func (course *Course) GetModules(r Requester) ([]Module, error) {
-	if outputDir == "" {
-		_ = os.Mkdir("out/"+strings.ReplaceAll(course.Name, " ", ""), 0777)
-	} else {
-		_ = os.Mkdir(outputDir+"/"+strings.ReplaceAll(course.Name, " ", ""), 0777)
-	}
-	req, err := http.NewRequest("GET", "https://"+r.BaseURL+r.Context+strconv.Itoa(course.ID)+"/modules/?per_page=1000", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("Authorization", r.Headers["Authorization"])
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	modules := make([]Module, 0)
-	body, err := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &modules)
+func (course *Course) GetModules(ctx context.Context, r Requester) ([]Module, error) {
+	modules, err := r.ListModules(ctx, course.ID)
 	if err != nil {
 		return nil, err
 	}
 	if len(modules) == 0 {
-		fmt.Printf("course, %s, does not use modules page \n ", strings.ReplaceAll(course.Name, " ", ""))
+		r.Log.Debug("Course does not use modules", "course", course.Name, "course_id", course.ID)
 		return nil, &NoModulesError{course.Name}
 
 	} else {
@@ -365,25 +372,9 @@ func (course *Course) GetModules(r Requester) ([]Module, error) {
 	}
 
 }
-func (module *Module) GetFolders(r Requester) ([]Folder, error) {
-
-	req, _ := http.NewRequest("GET", module.ItemsURL, nil)
-	req.Header.Add("Authorization", r.Headers["Authorization"])
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func (module *Module) GetFolders(ctx context.Context, r Requester) ([]Folder, error) {
 	folders := make([]Folder, 0)
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	err = json.Unmarshal(body, &folders)
-	if err != nil {
-		return nil, err
-	}
-	err = resp.Body.Close()
+	err := r.getPaginated(ctx, module.ItemsURL, &folders)
 	if err != nil {
 		return nil, err
 	}
@@ -392,139 +383,138 @@ func (module *Module) GetFolders(r Requester) ([]Folder, error) {
 
 }
 
-func (folder *Folder) GetFiles(r Requester, course Course) error {
-	if (folder.URL != "") && !strings.Contains(folder.URL, "/quizzes/") {
-		req, _ := http.NewRequest("GET", folder.URL, nil)
-		req.Header.Add("Authorization", r.Headers["Authorization"])
-		resp, err := http.DefaultClient.Do(req)
+// GetFiles schedules the file a module item in module refers to, or the files linked from a page or,
+// if e exports them, assignment or discussion item, for download. Pages, assignments and discussions
+// are added to e, which may be nil.
+func (folder *Folder) GetFiles(ctx context.Context, r Requester, course Course, module Module, s *Scheduler, e *Exporter) error {
+	if folder.URL == "" || strings.Contains(folder.URL, "/quizzes/") {
+		return nil
+	}
+	source := FileSource{Course: course, Module: module, Item: *folder}
+	if folder.Type == "Assignment" {
+		if !e.exports(assignmentsDir) {
+			return nil
+		}
+		var assignment Assignment
+		err := r.getJSON(ctx, folder.URL, &assignment)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
+		return getAssignmentFiles(ctx, r, s, e, source, assignment)
+	}
+	if folder.Type == "Discussion" {
+		if !e.exports(discussionsDir) {
+			return nil
+		}
+		var topic DiscussionTopic
+		err := r.getJSON(ctx, folder.URL, &topic)
+		if err != nil {
+			return err
+		}
+		return getTopicFiles(ctx, r, s, e, source, discussionsDir, topic)
+	}
+	if !strings.Contains(folder.URL, "/pages/") {
+		if folder.Type != "" && folder.Type != "File" {
+			// assignments, discussions and the like are not files
+			return nil
+		}
 		var file File
-		body, err := ioutil.ReadAll(resp.Body)
-        if strings.Contains(folder.URL, "/pages/"){
-            var page Page
-            err = json.Unmarshal(body, &page)
-            //fmt.Printf("Page: %v\n",page)
-            body_list := strings.Split(page.Body," ")
-            urls := make([]string,1)
-            for _,each := range body_list{
-                if strings.Contains(each,"canvas.bham.ac.uk"){
-                    urls = append(urls, each)
-                }
-            }
-            //fmt.Printf("DETECTED URLS: %v\n", urls)
-            for _, url := range urls{
-                if strings.Contains(url, "files") && strings.Contains(url, "api"){
-                    url_re := regexp.MustCompile(`https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}\b([-a-zA-Z0-9()@:%_\+.~#?&//=]*)`)
-                    file_url := url_re.FindString(url)
-
-                    req, err := http.NewRequest("GET", file_url, nil)
-                    if err != nil {
-                        log.Fatal(err)
-                    }
-                    req.Header.Add("Authorization", r.Headers["Authorization"])
-                    resp, err := http.DefaultClient.Do(req)
-                    if err != nil {
-                        return err
-                    }
-		            body, err := ioutil.ReadAll(resp.Body)
-                    if err != nil {
-                        return err
-                    }
-                    json.Unmarshal(body, &file)
-                    err = resp.Body.Close()
-                    if err != nil {
-                        return err
-                    }
-                    fmt.Printf("Downloading file: %v\n", file.DisplayName)
-                    filename := strings.ReplaceAll("out/"+course.Name+"/"+file.Filename, " ", "")
-                    _, err = os.Stat(filename)
-                    if forceDownloadAll || os.IsNotExist(err) {
-                        file.Download(course, r)
-                        if err != nil {
-                            return err
-                        }
-                    }
-
-
-
-                }
-
-            }
-
-        }else{
-            if err != nil {
-                return err
-            }
-            err = json.Unmarshal(body, &file)
-            if err != nil {
-                fmt.Printf("%s cannot be unmarshalled from folder", body)
-                return err
-            }
-            err = resp.Body.Close()
-            if err != nil {
-                return err
-            }
-            filename := strings.ReplaceAll("out/"+course.Name+"/"+file.Filename, " ", "")
-            _, err = os.Stat(filename)
-            if forceDownloadAll || os.IsNotExist(err) {
-                file.Download(course, r)
-                if err != nil {
-                    return err
-                }
-            }
-        }
-    }
+		err := r.getJSON(ctx, folder.URL, &file)
+		if err != nil {
+			return err
+		}
+		s.Add(source, file)
+		return nil
+	}
+
+	var page Page
+	err := r.getJSON(ctx, folder.URL, &page)
+	if err != nil {
+		return err
+	}
+	e.AddPage(course, page)
+	var failed []error
+	for _, id := range ExtractFileIDs(page.Body, r.host()) {
+		file, err := r.GetCourseFile(ctx, course.ID, id)
+		if err != nil {
+			failed = append(failed, err)
+			continue
+		}
+		r.Log.Debug("Found linked file", "course", course.Name, "module", module.Name, "page", page.Title, "file_id", file.ID, "name", file.DisplayName)
+		s.Add(source, file)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("page %s: %d linked files could not be fetched, the first: %w", page.Title, len(failed), failed[0])
+	}
 	return nil
 }
 
-var (
-	forceDownloadAll bool
-	outputDir        string
-)
-
-func ReadConfig() (*viper.Viper, error) {
-	fmt.Println("Reading config file")
+// defaultBaseURL is the Canvas instance used when none is configured
+const defaultBaseURL = "https://canvas.bham.ac.uk"
 
+// ReadConfig reads the yaml config file at path, or config.yaml in the working directory if path is empty
+func ReadConfig(path string) (*viper.Viper, error) {
 	v := viper.New()
-	v.AddConfigPath(".")
-	v.SetConfigName("config")
-	v.SetConfigType("yaml")
+	if path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.AddConfigPath(".")
+		v.SetConfigName("config")
+		v.SetConfigType("yaml")
+	}
 	v.AutomaticEnv()
 	v.SetDefault("AuthToken", "token")
+	v.SetDefault("BaseURL", defaultBaseURL)
 	err := v.ReadInConfig()
 	return v, err
 }
 
-func GetRequester() (Requester, error) {
-	fmt.Println("listing modules")
-	baseUrl := "canvas.bham.ac.uk"
-	config, err := ReadConfig()
-	if err != nil {
-		return Requester{}, err
+// ProfileString returns key from the named instance's section of config,
+// falling back to the top-level value when the instance does not set it
+func ProfileString(config *viper.Viper, instance, key string) string {
+	if instance != "" {
+		if k := "Instances." + instance + "." + key; config.IsSet(k) {
+			return config.GetString(k)
+		}
 	}
-	authToken := config.GetString("AuthToken")
+	return config.GetString(key)
+}
 
-	headers := make(map[string]string)
+// normaliseBaseURL prefixes baseURL with https:// if it has no scheme and strips any trailing slash
+func normaliseBaseURL(baseURL string) string {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "https://" + baseURL
+	}
+	return strings.TrimRight(baseURL, "/")
+}
 
-	headers["Authorization"] = "Bearer " + authToken
+// GetRequester builds a Requester for the named Canvas instance in config, or the instance named by
+// the config's Instance key if instance is empty. A non-empty baseURL overrides the configured one.
+// Events are logged to logger, including every request at LevelTrace.
+func GetRequester(config *viper.Viper, instance, baseURL string, logger *Logger) (Requester, error) {
+	if instance == "" {
+		instance = config.GetString("Instance")
+	}
+	if instance != "" && !config.IsSet("Instances."+instance) {
+		return Requester{}, fmt.Errorf("no instance named %q in config", instance)
+	}
+	if baseURL == "" {
+		baseURL = ProfileString(config, instance, "BaseURL")
+	}
+	authToken := ProfileString(config, instance, "AuthToken")
 
-	dat, err := ioutil.ReadFile(".scrapeignore")
+	ignore, err := LoadIgnore(".scrapeignore")
 	if err != nil {
-		log.Fatal(err)
+		return Requester{}, err
 	}
-	strData := string(dat)
-	ignore := strings.Split(strData, "\n")
-	ignore = ignore[:len(ignore)-1] // remove last empty value
-	println("Ignoring the following extensions:\n " + strData)
+	ignore.log = logger
+	logger.Debug("Loaded ignore rules", "rules", ignore.Len())
 
 	requester := Requester{
-		Context: "/api/v1/courses?per_page=1000",
-		Headers: headers,
-		BaseURL: baseUrl,
-		Ignore:  ignore,
+		Client:   NewClient(baseURL, authToken, WithMiddleware(LogRequests(logger))),
+		Ignore:   ignore,
+		Instance: instance,
+		Log:      logger,
 	}
 
 	return requester, nil
@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRequester returns a Requester talking to srv, without retries so errors come back at once
+func testRequester(srv *httptest.Server) Requester {
+	return Requester{Client: NewClient(srv.URL, "tok", WithoutRetries())}
+}
+
+func TestContradicts(t *testing.T) {
+	tests := []struct {
+		got, expected string
+		want          bool
+	}{
+		{"application/pdf", "application/pdf", false},
+		{"application/pdf; charset=binary", "application/pdf", false},
+		{"text/html; charset=utf-8", "application/pdf", true},
+		{"application/json", "application/pdf", true},
+		{"image/png", "application/pdf", true},
+		{"image/jpeg", "image/png", false},
+		{"application/octet-stream", "application/pdf", false},
+		{"", "application/pdf", false},
+		{"text/html", "", false},
+	}
+	for _, tt := range tests {
+		if got := contradicts(tt.got, tt.expected); got != tt.want {
+			t.Errorf("contradicts(%q, %q) = %v, want %v", tt.got, tt.expected, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadRefusesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		check       func(error) bool
+	}{
+		{"login page", http.StatusOK, "text/html; charset=utf-8", func(err error) bool {
+			var typeErr *ContentTypeError
+			return errors.As(err, &typeErr)
+		}},
+		{"error status", http.StatusNotFound, "application/pdf", func(err error) bool {
+			return errors.Is(err, ErrNotFound)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte("<html>log in</html>"))
+			}))
+			defer srv.Close()
+
+			dest := filepath.Join(t.TempDir(), "notes.pdf")
+			file := File{ID: 1, URL: srv.URL + "/files/1/download", ContentType: "application/pdf", Size: 19}
+			_, err := file.Download(context.Background(), dest, testRequester(srv))
+			if err == nil || !tt.check(err) {
+				t.Fatalf("got %v, want the download refused", err)
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Errorf("the refused download was written to %s", dest)
+			}
+		})
+	}
+}
+
+func TestWritePart(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		size int64
+		err  bool
+	}{
+		{"complete", "0123456789", 10, false},
+		{"size unknown", "0123456789", 0, false},
+		{"short", "01234", 10, true},
+		{"long", "0123456789ab", 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := os.Create(filepath.Join(t.TempDir(), "part"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := writePart(out, strings.NewReader(tt.body), tt.size)
+			if (err != nil) != tt.err {
+				t.Errorf("writePart() error = %v, want error %v", err, tt.err)
+			}
+			if n != int64(len(tt.body)) {
+				t.Errorf("writePart() wrote %d bytes, want %d", n, len(tt.body))
+			}
+			if err := out.Close(); err == nil {
+				t.Error("writePart() left the file open")
+			}
+		})
+	}
+}
+
+func TestDownloadShortBodyKeepsDestination(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("01234"))
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "notes.pdf")
+	if err := ioutil.WriteFile(dest, []byte("previous version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := File{ID: 1, URL: srv.URL + "/files/1/download", ContentType: "application/pdf", Size: 10}
+	_, err := file.Download(context.Background(), dest, testRequester(srv))
+	if err == nil || !strings.Contains(err.Error(), "received 5 of 10 bytes") {
+		t.Errorf("got %v, want the size mismatch reported", err)
+	}
+	if got := readFile(t, dest); got != "previous version" {
+		t.Errorf("destination = %q after a short download, want it untouched", got)
+	}
+}
+
+// readFile returns the contents of path, failing the test if it cannot be read
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dat)
+}
+
+// resumeServer serves content as a file with etag, recording the Range and If-Range headers of each request.
+// If ignoreRange is set it always answers with the whole file, if rejectRange with 416 to any Range request.
+type resumeServer struct {
+	content     string
+	etag        string
+	ignoreRange bool
+	rejectRange bool
+	ranges      []string
+	ifRanges    []string
+}
+
+func (s *resumeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.ifRanges = append(s.ifRanges, r.Header.Get("If-Range"))
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("ETag", s.etag)
+	switch {
+	case s.rejectRange && r.Header.Get("Range") != "":
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	case s.ignoreRange:
+		w.Write([]byte(s.content))
+	default:
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(s.content))
+	}
+}
+
+// startPart leaves a partial download of the first n bytes of content behind for file, as an
+// interrupted download of the version etag would
+func startPart(t *testing.T, dest string, file File, content string, n int, etag string) (string, string) {
+	t.Helper()
+	part, metaPath := partPaths(dest, file)
+	if err := ioutil.WriteFile(part, []byte(content[:n]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePartMeta(file, etag, metaPath); err != nil {
+		t.Fatal(err)
+	}
+	return part, metaPath
+}
+
+func TestDownloadResume(t *testing.T) {
+	const content = "0123456789"
+	updated := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		server resumeServer
+		// partVersion is when the file the partial download came from was updated
+		partVersion time.Time
+		wantRanges  []string
+		wantWritten int64
+	}{
+		{"resumed", resumeServer{etag: `"v1"`}, updated, []string{"bytes=4-"}, 6},
+		{"remote changed", resumeServer{etag: `"v2"`}, updated.Add(-time.Hour), []string{""}, 10},
+		{"server ignores range", resumeServer{etag: `"v1"`, ignoreRange: true}, updated, []string{"bytes=4-"}, 10},
+		{"range not satisfiable", resumeServer{etag: `"v1"`, rejectRange: true}, updated, []string{"bytes=4-", ""}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.server.content = content
+			srv := httptest.NewServer(&tt.server)
+			defer srv.Close()
+
+			dest := filepath.Join(t.TempDir(), "notes.pdf")
+			file := File{ID: 1, URL: srv.URL + "/files/1/download", ContentType: "application/pdf", Size: len(content), UpdatedAt: updated}
+			partFile := file
+			partFile.UpdatedAt = tt.partVersion
+			part, metaPath := startPart(t, dest, partFile, content, 4, `"v1"`)
+
+			n, err := file.Download(context.Background(), dest, testRequester(srv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantWritten {
+				t.Errorf("wrote %d bytes, want %d", n, tt.wantWritten)
+			}
+			if got := readFile(t, dest); got != content {
+				t.Errorf("destination = %q, want %q", got, content)
+			}
+			if !reflect.DeepEqual(tt.server.ranges, tt.wantRanges) {
+				t.Errorf("Range headers = %q, want %q", tt.server.ranges, tt.wantRanges)
+			}
+			if tt.wantRanges[0] != "" && tt.server.ifRanges[0] != `"v1"` {
+				t.Errorf("If-Range = %q, want the ETag the partial download was served with", tt.server.ifRanges[0])
+			}
+			for _, p := range []string{part, metaPath} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("%s was left behind", filepath.Base(p))
+				}
+			}
+		})
+	}
+}
+
+func TestResumed(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentRange string
+		offset       int64
+		want         bool
+	}{
+		{"partial content", http.StatusPartialContent, "bytes 4-9/10", 4, true},
+		{"other start", http.StatusPartialContent, "bytes 0-9/10", 4, false},
+		{"no offset", http.StatusPartialContent, "bytes 0-9/10", 0, false},
+		{"whole file", http.StatusOK, "", 4, false},
+		{"missing header", http.StatusPartialContent, "", 4, false},
+		{"malformed header", http.StatusPartialContent, "bytes */10", 4, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		resp.Header.Set("Content-Range", tt.contentRange)
+		if got := resumed(resp, tt.offset); got != tt.want {
+			t.Errorf("%s: resumed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
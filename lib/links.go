@@ -0,0 +1,283 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// fileLinkPath matches the paths of Canvas files, such as /courses/1/files/2/download or /api/v1/files/2
+	fileLinkPath = regexp.MustCompile(`^(?:/api/v1)?(?:/courses/(\d+)|/(?:users|groups)/\d+)?/files/(\d+)(?:/|$)`)
+	// pageLinkPath matches the paths of Canvas pages, such as /courses/1/pages/intro
+	pageLinkPath = regexp.MustCompile(`^(?:/api/v1)?/courses/(\d+)/(?:pages|wiki)/([^/]+)/?$`)
+	// assignmentLinkPath matches the paths of Canvas assignments, such as /courses/1/assignments/5
+	assignmentLinkPath = regexp.MustCompile(`^(?:/api/v1)?/courses/(\d+)/assignments/(\d+)/?$`)
+	// topicLinkPath matches the paths of Canvas discussion topics and announcements, such as /courses/1/discussion_topics/7
+	topicLinkPath = regexp.MustCompile(`^(?:/api/v1)?/courses/(\d+)/(?:discussion_topics|announcements)/(\d+)/?$`)
+)
+
+// canvasLink is what a URL found in an HTML body refers to within Canvas
+type canvasLink struct {
+	// CourseID is the ID of the course the URL lies within, if any
+	CourseID int
+	// FileID is the ID of the file the URL refers to, if any
+	FileID int
+	// Page is the URL slug of the page the URL refers to, if any
+	Page string
+	// AssignmentID is the ID of the assignment the URL refers to, if any
+	AssignmentID int
+	// TopicID is the ID of the discussion topic or announcement the URL refers to, if any
+	TopicID int
+}
+
+// parseCanvasLink parses rawURL, which may be relative, reporting whether it lies within the Canvas
+// instance on host
+func parseCanvasLink(rawURL, host string) (canvasLink, bool) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") ||
+		(u.Host != "" && !strings.EqualFold(u.Host, host)) || !strings.HasPrefix(u.Path, "/") {
+		return canvasLink{}, false
+	}
+	var link canvasLink
+	if m := fileLinkPath.FindStringSubmatch(u.Path); m != nil {
+		link.CourseID, _ = strconv.Atoi(m[1])
+		link.FileID, _ = strconv.Atoi(m[2])
+	} else if m := pageLinkPath.FindStringSubmatch(u.Path); m != nil {
+		link.CourseID, _ = strconv.Atoi(m[1])
+		link.Page = m[2]
+	} else if m := assignmentLinkPath.FindStringSubmatch(u.Path); m != nil {
+		link.CourseID, _ = strconv.Atoi(m[1])
+		link.AssignmentID, _ = strconv.Atoi(m[2])
+	} else if m := topicLinkPath.FindStringSubmatch(u.Path); m != nil {
+		link.CourseID, _ = strconv.Atoi(m[1])
+		link.TopicID, _ = strconv.Atoi(m[2])
+	}
+	return link, true
+}
+
+// fileAttrs are the attributes Canvas HTML refers to files through
+var fileAttrs = map[string]bool{"href": true, "src": true, "data-api-endpoint": true}
+
+// ExtractFileIDs returns the ID of every Canvas file an HTML body, such as that of a page, assignment,
+// announcement or syllabus, links to or embeds through href, src or data-api-endpoint attributes, in
+// the order they first appear. Links may be relative or absolute on host, other hosts are ignored.
+func ExtractFileIDs(body, host string) []int {
+	var ids []int
+	seen := make(map[int]bool)
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// io.EOF at the end of body, the tokenizer reads nothing else which can fail
+			return ids
+		case html.StartTagToken, html.SelfClosingTagToken:
+			for _, a := range z.Token().Attr {
+				if !fileAttrs[a.Key] {
+					continue
+				}
+				link, ok := parseCanvasLink(a.Val, host)
+				if ok && link.FileID != 0 && !seen[link.FileID] {
+					seen[link.FileID] = true
+					ids = append(ids, link.FileID)
+				}
+			}
+		}
+	}
+}
+
+// unresolvedClass is added to the links in exported content which still point at Canvas
+const unresolvedClass = "canvas-unresolved"
+
+// rewriteLinks returns the body of doc with its links to Canvas files and exported content pointing
+// at their local copies, relative to dir, and how many links could not be resolved. Links which
+// cannot be resolved keep pointing at Canvas and are marked as not available offline.
+func (e *Exporter) rewriteLinks(ctx context.Context, manifest *Manifest, doc *document, dir string, format ExportFormat) (string, int, error) {
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(doc.body), root)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	// collect the links first, as marking one inserts a node after it
+	var links []*html.Node
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.DataAtom == atom.A || n.DataAtom == atom.Img) {
+			links = append(links, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(root)
+
+	unresolved := 0
+	for _, n := range links {
+		key := "href"
+		if n.DataAtom == atom.Img {
+			key = "src"
+		}
+		rawURL := attr(n, key)
+		link, ok := parseCanvasLink(rawURL, e.r.host())
+		if !ok {
+			continue
+		}
+		local := e.resolve(ctx, manifest, doc, link, n.DataAtom == atom.Img, format)
+		if local == "" {
+			unresolved++
+			// relative links only work on Canvas itself
+			if u, err := url.Parse(e.r.BaseURL); err == nil {
+				if abs, err := u.Parse(strings.TrimSpace(rawURL)); err == nil {
+					setAttr(n, key, abs.String())
+				}
+			}
+			markUnresolved(n)
+			e.r.Log.Debug("Link not available offline", "course", doc.course.Name, "title", doc.title, "url", stripQuery(rawURL))
+			continue
+		}
+		rel, err := filepath.Rel(dir, local)
+		if err != nil {
+			rel = local
+		}
+		setAttr(n, key, (&url.URL{Path: filepath.ToSlash(rel)}).String())
+		// Canvas' scripts would fetch the file through the API
+		removeAttr(n, "data-api-endpoint")
+		removeAttr(n, "data-api-returntype")
+	}
+
+	var buf bytes.Buffer
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buf, c); err != nil {
+			return "", 0, err
+		}
+	}
+	return buf.String(), unresolved, nil
+}
+
+// resolve returns the path of the local copy of what link, found in doc, refers to, downloading it
+// if it is an image, or an empty string if there is none
+func (e *Exporter) resolve(ctx context.Context, manifest *Manifest, doc *document, link canvasLink, image bool, format ExportFormat) string {
+	var target *document
+	switch {
+	case link.FileID != 0:
+		if entry, ok := manifest.Lookup(link.FileID); ok {
+			if _, err := os.Stat(entry.Path); err == nil {
+				return entry.Path
+			}
+		}
+		if image {
+			return e.image(ctx, doc, link)
+		}
+		return ""
+	case link.Page != "":
+		target = e.lookup(link.CourseID, pagesDir, link.Page)
+	case link.AssignmentID != 0:
+		target = e.lookup(link.CourseID, assignmentsDir, strconv.Itoa(link.AssignmentID))
+	case link.TopicID != 0:
+		target = e.lookup(link.CourseID, discussionsDir, strconv.Itoa(link.TopicID))
+		if target == nil {
+			target = e.lookup(link.CourseID, announcementsDir, strconv.Itoa(link.TopicID))
+		}
+	}
+	if target == nil {
+		return ""
+	}
+	// link to the copy in the same format if there is one, or else one people can read
+	best := target.formats[0]
+	for _, f := range target.formats {
+		if f == format {
+			return e.docPath(target, format)
+		}
+		if best == ExportJSON {
+			best = f
+		}
+	}
+	return e.docPath(target, best)
+}
+
+// imagesDir is the directory inline images are downloaded to, within the directory of the content
+// which first embeds them
+const imagesDir = "images"
+
+// image downloads the file link refers to, used as an inline image in doc, returning its path or an
+// empty string if it could not be downloaded or is excluded like any other file. Each image is only
+// downloaded once.
+func (e *Exporter) image(ctx context.Context, doc *document, link canvasLink) string {
+	e.mu.Lock()
+	path, done := e.images[link.FileID]
+	e.mu.Unlock()
+	if done || ctx.Err() != nil {
+		return path
+	}
+	var file File
+	var err error
+	if link.CourseID != 0 {
+		file, err = e.r.GetCourseFile(ctx, link.CourseID, link.FileID)
+	} else {
+		file, err = e.r.GetFile(ctx, link.FileID)
+	}
+	if err == nil && (e.r.Ignore.Ignored(FileSource{Course: doc.course}, file) || !e.opts.Filter.Match(file)) {
+		e.r.Log.Debug("Excluded image", "course", doc.course.Name, "file_id", file.ID, "name", file.DisplayName)
+	} else if err == nil {
+		dest := filepath.Join(e.courseDir(doc.course), doc.kind, imagesDir, strconv.Itoa(file.ID)+"-"+SanitiseName(file.Filename))
+		if _, err = os.Stat(dest); err != nil {
+			_, err = file.Download(ctx, dest, e.r)
+		}
+		if err == nil {
+			path = dest
+		}
+	}
+	if err != nil {
+		e.r.Log.Warn("Could not download image", "course", doc.course.Name, "file_id", link.FileID, "error", err)
+	}
+	e.mu.Lock()
+	e.images[link.FileID] = path
+	e.mu.Unlock()
+	return path
+}
+
+// markUnresolved marks n, a link or image which keeps pointing at Canvas, as not available offline
+func markUnresolved(n *html.Node) {
+	setAttr(n, "class", strings.TrimSpace(attr(n, "class")+" "+unresolvedClass))
+	setAttr(n, "title", "Not available offline")
+	marker := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "span",
+		DataAtom: atom.Span,
+		Attr:     []html.Attribute{{Key: "class", Val: unresolvedClass + "-marker"}},
+	}
+	marker.AppendChild(&html.Node{Type: html.TextNode, Data: " (not available offline)"})
+	n.Parent.InsertBefore(marker, n.NextSibling)
+}
+
+// setAttr sets n's attribute key to val
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// removeAttr removes n's attribute key, if it has one
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCanvasLink(t *testing.T) {
+	tests := []struct {
+		url  string
+		want canvasLink
+		ok   bool
+	}{
+		{"/courses/1/pages/intro", canvasLink{CourseID: 1, Page: "intro"}, true},
+		{"https://canvas.test/courses/1/wiki/intro/", canvasLink{CourseID: 1, Page: "intro"}, true},
+		{"/courses/1/assignments/5", canvasLink{CourseID: 1, AssignmentID: 5}, true},
+		{"/courses/1/discussion_topics/6", canvasLink{CourseID: 1, TopicID: 6}, true},
+		{"/api/v1/courses/1/files/2", canvasLink{CourseID: 1, FileID: 2}, true},
+		{"/courses/1/quizzes/3", canvasLink{}, true},
+		{"https://elsewhere.test/courses/1/pages/intro", canvasLink{}, false},
+		{"intro.html", canvasLink{}, false},
+		{"ftp://canvas.test/courses/1/files/2", canvasLink{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseCanvasLink(tt.url, "canvas.test")
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseCanvasLink(%q) = %+v, %v, want %+v, %v", tt.url, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExtractFileIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []int
+	}{
+		{"none", `<p>No files here</p>`, nil},
+		{"relative link", `<a href="/courses/1/files/2/download?wrap=1">notes</a>`, []int{2}},
+		{"absolute link", `<a href="https://canvas.test/courses/1/files/3">notes</a>`, []int{3}},
+		{"other host", `<a href="https://elsewhere.test/courses/1/files/3">notes</a>`, nil},
+		{"API endpoint", `<a href="#" data-api-endpoint="https://canvas.test/api/v1/courses/1/files/4">x</a>`, []int{4}},
+		{"image preview", `<img src="/courses/1/files/5/preview">`, []int{5}},
+		{"user file", `<img src="/users/9/files/6/download" />`, []int{6}},
+		{"global file", `<a href="/files/7">x</a>`, []int{7}},
+		{"order and duplicates", `<a href="/courses/1/files/9">a</a><a href="/courses/1/files/8" data-api-endpoint="/api/v1/courses/1/files/8">b</a><img src="/courses/1/files/9/preview">`,
+			[]int{9, 8}},
+		{"text is not a link", `<p>see /courses/1/files/10</p>`, nil},
+		{"other attributes", `<a title="/courses/1/files/11" href="/courses/1/pages/intro">page</a>`, nil},
+		{"mailto", `<a href="mailto:a@canvas.test">mail</a>`, nil},
+		{"unclosed markup", `<p><a href="/courses/1/files/12">x`, []int{12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractFileIDs(tt.body, "canvas.test"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractFileIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
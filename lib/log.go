@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log event, a Logger writes the events at or above its own level
+type Level int
+
+const (
+	// LevelTrace logs every HTTP request
+	LevelTrace Level = iota
+	// LevelDebug logs every decision made about every file
+	LevelDebug
+	// LevelInfo logs progress, such as each course searched and file downloaded
+	LevelInfo
+	// LevelWarn logs problems which do not stop a run
+	LevelWarn
+	// LevelError logs failures
+	LevelError
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelTrace || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// LogFormat selects how a Logger writes events
+type LogFormat string
+
+const (
+	// LogText writes each event as its message followed by key=value pairs
+	LogText LogFormat = "text"
+	// LogJSON writes each event as a JSON object on its own line, with time, level and msg keys
+	// alongside its own. Durations are written in seconds.
+	LogJSON LogFormat = "json"
+)
+
+// ParseLogFormat parses a log format name, empty meaning LogText
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(strings.ToLower(s)); f {
+	case "":
+		return LogText, nil
+	case LogText, LogJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q, expected text or json", s)
+}
+
+// Logger writes leveled, structured events. Events carry alternating keys and values, such as
+// "course", course.Name, "file_id", file.ID. A nil *Logger discards every event.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format LogFormat
+}
+
+// NewLogger returns a Logger writing events at or above level to w in format
+func NewLogger(w io.Writer, level Level, format LogFormat) *Logger {
+	return &Logger{w: w, level: level, format: format}
+}
+
+// SetOutput makes the logger write to w from now on
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w = w
+}
+
+// Enabled reports whether events at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Trace logs msg at LevelTrace
+func (l *Logger) Trace(msg string, keyvals ...interface{}) {
+	l.Log(LevelTrace, msg, keyvals...)
+}
+
+// Debug logs msg at LevelDebug
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(LevelDebug, msg, keyvals...)
+}
+
+// Info logs msg at LevelInfo
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(LevelInfo, msg, keyvals...)
+}
+
+// Warn logs msg at LevelWarn
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(LevelWarn, msg, keyvals...)
+}
+
+// Error logs msg at LevelError
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+}
+
+// Log writes msg with keyvals if level is enabled
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	if len(keyvals)%2 == 1 {
+		keyvals = append(keyvals, "(missing)")
+	}
+	var buf bytes.Buffer
+	if l.format == LogJSON {
+		writeJSONEvent(&buf, level, msg, keyvals)
+	} else {
+		writeTextEvent(&buf, level, msg, keyvals)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+func writeTextEvent(buf *bytes.Buffer, level Level, msg string, keyvals []interface{}) {
+	if level != LevelInfo {
+		buf.WriteString(level.String() + ": ")
+	}
+	buf.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		value := fmt.Sprint(textValue(keyvals[i+1]))
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(buf, " %v=%s", keyvals[i], value)
+	}
+	buf.WriteByte('\n')
+}
+
+func writeJSONEvent(buf *bytes.Buffer, level Level, msg string, keyvals []interface{}) {
+	buf.WriteString(`{"time":`)
+	writeJSONValue(buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		buf.WriteByte(',')
+		writeJSONValue(buf, fmt.Sprint(keyvals[i]))
+		buf.WriteByte(':')
+		writeJSONValue(buf, jsonValue(keyvals[i+1]))
+	}
+	buf.WriteString("}\n")
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// textValue converts v to the value written in text events
+func textValue(v interface{}) interface{} {
+	if d, ok := v.(time.Duration); ok {
+		return d.Round(time.Millisecond)
+	}
+	return v
+}
+
+// jsonValue converts v to the value written in JSON events
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.Seconds()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// LogRequests returns Middleware logging every request with its status and duration at LevelTrace
+func LogRequests(l *Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				l.Trace("request failed", "method", req.Method, "url", stripQuery(req.URL.Redacted()), "duration", time.Since(start), "error", err)
+				return nil, err
+			}
+			l.Trace("request", "method", req.Method, "url", stripQuery(req.URL.Redacted()), "status", resp.StatusCode, "duration", time.Since(start))
+			return resp, nil
+		})
+	}
+}
+
+// stripQuery drops the query from rawURL before it is logged, as Canvas puts file verifiers and signatures there
+func stripQuery(rawURL string) string {
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+// roundTripperFunc adapts a function to an http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
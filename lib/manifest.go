@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestFile is the name of the manifest kept in the output directory
+const manifestFile = ".canvas-sync.json"
+
+// manifestVersion is the version of the manifest's format. Manifests without one hold paths relative
+// to the working directory they were written from rather than to their own directory.
+const manifestVersion = 1
+
+// ManifestEntry records where a Canvas file came from, where it was written and what it looked like
+// when it was last downloaded. The manifest's file keeps Path relative to its own directory, so the
+// output directory can be moved or used from elsewhere, while a loaded Manifest returns it joined to it.
+type ManifestEntry struct {
+	ID           int       `json:"id"`
+	UUID         string    `json:"uuid,omitempty"`
+	CourseID     int       `json:"course_id,omitempty"`
+	Course       string    `json:"course,omitempty"`
+	ModuleID     int       `json:"module_id,omitempty"`
+	Module       string    `json:"module,omitempty"`
+	SourceURL    string    `json:"source_url,omitempty"`
+	Path         string    `json:"path"`
+	Size         int       `json:"size"`
+	Checksum     string    `json:"sha256,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ModifiedAt   time.Time `json:"modified_at"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// Manifest is the persistent record of every file downloaded into an output directory, keyed by
+// Canvas file ID. Read it through Lookup, LookupUUID and Entries. It is safe for concurrent use.
+type Manifest struct {
+	path string
+	// dir is the directory the paths in files are relative to, empty if they are used as they are
+	dir   string
+	mu    sync.Mutex
+	files map[int]ManifestEntry
+}
+
+// manifestData is the manifest's file
+type manifestData struct {
+	Version int                   `json:"version"`
+	Files   map[int]ManifestEntry `json:"files"`
+}
+
+// NewManifest returns an empty manifest which is not backed by a file
+func NewManifest() *Manifest {
+	return &Manifest{files: make(map[int]ManifestEntry)}
+}
+
+// LoadManifest reads the manifest kept in dir, returning an empty manifest if there is none yet
+func LoadManifest(dir string) (*Manifest, error) {
+	m := NewManifest()
+	m.path = filepath.Join(dir, manifestFile)
+	m.dir = dir
+	dat, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data manifestData
+	err = json.Unmarshal(dat, &data)
+	if err != nil {
+		return nil, err
+	}
+	for id, entry := range data.Files {
+		if data.Version < manifestVersion {
+			if rel, err := filepath.Rel(dir, entry.Path); err == nil {
+				entry.Path = rel
+			}
+		}
+		m.files[id] = entry
+	}
+	return m, nil
+}
+
+// Lookup returns the entry for the Canvas file with the given ID
+func (m *Manifest) Lookup(id int) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.files[id]
+	return m.resolve(entry), ok
+}
+
+// LookupUUID returns the entry for the Canvas file with the given UUID
+func (m *Manifest) LookupUUID(uuid string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.files {
+		if entry.UUID == uuid {
+			return m.resolve(entry), true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// Entries returns every entry ordered by course, then path
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	entries := make([]ManifestEntry, 0, len(m.files))
+	for _, entry := range m.files {
+		entries = append(entries, m.resolve(entry))
+	}
+	m.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CourseID != entries[j].CourseID {
+			return entries[i].CourseID < entries[j].CourseID
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// Changed reports whether file's metadata differs from when it was last downloaded, or it never was
+func (m *Manifest) Changed(file File) bool {
+	entry, ok := m.Lookup(file.ID)
+	return !ok || entry.Size != file.Size || !entry.UpdatedAt.Equal(file.UpdatedAt) ||
+		!entry.ModifiedAt.Equal(file.ModifiedAt)
+}
+
+// Record adds or replaces the entry for entry.ID
+func (m *Manifest) Record(entry ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir != "" {
+		if rel, err := filepath.Rel(m.dir, entry.Path); err == nil {
+			entry.Path = rel
+		}
+	}
+	m.files[entry.ID] = entry
+}
+
+// resolve returns entry, as kept in files, with its path usable from the working directory
+func (m *Manifest) resolve(entry ManifestEntry) ManifestEntry {
+	if m.dir != "" && entry.Path != "" && !filepath.IsAbs(entry.Path) {
+		entry.Path = filepath.Join(m.dir, entry.Path)
+	}
+	return entry
+}
+
+// Save writes the manifest back to the output directory, replacing the previous one atomically.
+// It does nothing for a manifest not read from a directory.
+func (m *Manifest) Save() error {
+	if m.path == "" {
+		return nil
+	}
+	m.mu.Lock()
+	dat, err := json.MarshalIndent(manifestData{Version: manifestVersion, Files: m.files}, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, dat)
+}
+
+// writeFileAtomic writes data to path through a temporary file, creating its directory if needed,
+// so path is never left half written
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newManifestEntry describes file from course and module, downloaded to path
+func newManifestEntry(course Course, module Module, file File, path string) (ManifestEntry, error) {
+	sum, err := checksum(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	return ManifestEntry{
+		ID:           file.ID,
+		UUID:         file.UUID,
+		CourseID:     course.ID,
+		Course:       course.Name,
+		ModuleID:     module.ID,
+		Module:       module.Name,
+		SourceURL:    file.URL,
+		Path:         path,
+		Size:         file.Size,
+		Checksum:     sum,
+		UpdatedAt:    file.UpdatedAt,
+		ModifiedAt:   file.ModifiedAt,
+		DownloadedAt: time.Now().UTC(),
+	}, nil
+}
+
+// checksum returns the hex encoded SHA-256 of the file at path
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
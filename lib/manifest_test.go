@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestPaths(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "Intro", "notes.pdf")
+	m.Record(ManifestEntry{ID: 1, UUID: "u1", Path: path})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var data struct {
+		Version int `json:"version"`
+		Files   map[string]struct {
+			Path string `json:"path"`
+		} `json:"files"`
+	}
+	dat, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err == nil {
+		err = json.Unmarshal(dat, &data)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("Intro", "notes.pdf"); data.Files["1"].Path != want || data.Version != manifestVersion {
+		t.Errorf("saved version %d with path %q, want version %d with %q", data.Version, data.Files["1"].Path, manifestVersion, want)
+	}
+
+	// the paths are usable however the output directory is reached
+	m, err = LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := m.Lookup(1); !ok || entry.Path != path {
+		t.Errorf("Lookup(1) = %q, %v, want %q", entry.Path, ok, path)
+	}
+	if entry, ok := m.LookupUUID("u1"); !ok || entry.Path != path {
+		t.Errorf("LookupUUID(u1) = %q, %v, want %q", entry.Path, ok, path)
+	}
+	if entries := m.Entries(); len(entries) != 1 || entries[0].Path != path {
+		t.Errorf("Entries() = %+v, want the entry at %q", entries, path)
+	}
+	if _, ok := m.Lookup(2); ok {
+		t.Error("Lookup(2) found an entry which was never recorded")
+	}
+}
+
+func TestLoadManifestVersion0(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.Join(t.TempDir(), "out")
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// manifests without a version hold paths relative to the working directory they were written from
+	for _, dir := range []string{abs, rel} {
+		old := filepath.Join(dir, "Intro", "notes.pdf")
+		dat, _ := json.Marshal(map[string]interface{}{"files": map[string]interface{}{"1": map[string]interface{}{"id": 1, "path": old}}})
+		if err := os.MkdirAll(abs, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(abs, manifestFile), dat, 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := LoadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry, _ := m.Lookup(1); entry.Path != old {
+			t.Errorf("loaded from %s: Lookup(1) = %q, want %q", dir, entry.Path, old)
+		}
+		if entry, want := m.files[1], filepath.Join("Intro", "notes.pdf"); entry.Path != want {
+			t.Errorf("loaded from %s: kept %q, want %q", dir, entry.Path, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToMarkdown converts an HTML fragment, such as a page body, to Markdown. Headings, paragraphs,
+// emphasis, links, images, lists, quotes, code and tables are kept, other elements are reduced to their text.
+func HTMLToMarkdown(body string) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", err
+	}
+	var w markdownWriter
+	for _, n := range nodes {
+		w.node(n)
+	}
+	return w.String(), nil
+}
+
+var (
+	blankLines = regexp.MustCompile(`\n{3,}`)
+	lineBreaks = regexp.MustCompile(`\n{2,}`)
+)
+
+// markdownWriter accumulates the Markdown for a tree of nodes
+type markdownWriter struct {
+	b strings.Builder
+}
+
+// String returns the Markdown written so far, with runs of blank lines collapsed
+func (w *markdownWriter) String() string {
+	lines := strings.Split(w.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func (w *markdownWriter) write(s string) {
+	w.b.WriteString(s)
+}
+
+// block separates what follows from what came before by a blank line
+func (w *markdownWriter) block() {
+	w.write("\n\n")
+}
+
+// atLineStart reports whether nothing has been written yet on the current line
+func (w *markdownWriter) atLineStart() bool {
+	s := w.b.String()
+	return s == "" || strings.HasSuffix(s, "\n")
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`)
+
+func (w *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.textNode(n.Data)
+	case html.ElementNode:
+		w.element(n)
+	case html.DocumentNode:
+		w.children(n)
+	}
+}
+
+// textNode writes text, keeping a single space where it began or ended with whitespace
+func (w *markdownWriter) textNode(data string) {
+	trimmed := strings.Join(strings.Fields(data), " ")
+	if trimmed == "" {
+		if data != "" && !w.atLineStart() && !strings.HasSuffix(w.b.String(), " ") {
+			w.write(" ")
+		}
+		return
+	}
+	if strings.TrimLeft(data, " \t\r\n") != data && !w.atLineStart() && !strings.HasSuffix(w.b.String(), " ") {
+		w.write(" ")
+	}
+	w.write(markdownEscaper.Replace(trimmed))
+	if strings.TrimRight(data, " \t\r\n") != data {
+		w.write(" ")
+	}
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+func (w *markdownWriter) element(n *html.Node) {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block()
+		w.write(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		w.write(inlineMarkdown(n))
+		w.block()
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Figure:
+		w.block()
+		w.children(n)
+		w.block()
+	case atom.Br:
+		// a backslash rather than two spaces, which would be trimmed from the line
+		w.write("\\\n")
+	case atom.Hr:
+		w.block()
+		w.write("---")
+		w.block()
+	case atom.Strong, atom.B:
+		w.wrap("**", n)
+	case atom.Em, atom.I:
+		w.wrap("*", n)
+	case atom.Code, atom.Kbd, atom.Samp:
+		w.write("`" + textContent(n) + "`")
+	case atom.Pre:
+		w.block()
+		w.write("```\n" + strings.Trim(textContent(n), "\n") + "\n```")
+		w.block()
+	case atom.A:
+		href := attr(n, "href")
+		text := inlineMarkdown(n)
+		if href == "" {
+			w.write(text)
+			return
+		}
+		if text == "" {
+			text = href
+		}
+		w.write("[" + text + "](" + markdownURL(href) + ")")
+	case atom.Img:
+		w.write("![" + markdownEscaper.Replace(attr(n, "alt")) + "](" + markdownURL(attr(n, "src")) + ")")
+	case atom.Ul, atom.Ol:
+		w.list(n)
+	case atom.Blockquote:
+		var inner markdownWriter
+		inner.children(n)
+		w.block()
+		w.write(prefixLines(inner.String(), "> ", "> "))
+		w.block()
+	case atom.Table:
+		w.table(n)
+	case atom.Script, atom.Style, atom.Head, atom.Title:
+	default:
+		w.children(n)
+	}
+}
+
+// wrap writes the content of n between marker
+func (w *markdownWriter) wrap(marker string, n *html.Node) {
+	inner := inlineMarkdown(n)
+	if inner == "" {
+		return
+	}
+	w.write(marker + inner + marker)
+}
+
+func (w *markdownWriter) list(n *html.Node) {
+	w.block()
+	i := 1
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(i) + ". "
+		}
+		var item markdownWriter
+		item.children(c)
+		w.write(prefixLines(lineBreaks.ReplaceAllString(item.String(), "\n"), marker, strings.Repeat(" ", len(marker))) + "\n")
+		i++
+	}
+	w.block()
+}
+
+func (w *markdownWriter) table(n *html.Node) {
+	var rows [][]string
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						row = append(row, strings.ReplaceAll(inlineMarkdown(cell), "|", `\|`))
+					}
+				}
+				rows = append(rows, row)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				visit(c)
+			}
+		}
+	}
+	visit(n)
+	if len(rows) == 0 {
+		return
+	}
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	w.block()
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		w.write("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			w.write("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	w.block()
+}
+
+// inlineMarkdown renders the content of n on a single line
+func inlineMarkdown(n *html.Node) string {
+	var inner markdownWriter
+	inner.children(n)
+	return strings.Join(strings.Fields(inner.String()), " ")
+}
+
+// textContent returns the text within n, as is
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// attr returns the value of n's attribute key, or an empty string if it has none
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// markdownURL makes u safe to use within the parentheses of a Markdown link
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u)
+}
+
+// prefixLines prefixes the first line of s with first and every other line with rest
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			lines[i] = strings.TrimRight(prefix, " ")
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+)
+
+// AddPages adds every page listed in course's Pages area which has not been added yet, if pages are exported
+func (e *Exporter) AddPages(ctx context.Context, course Course) error {
+	if !e.exports(pagesDir) {
+		return nil
+	}
+	pages, err := e.r.ListPages(ctx, course.ID)
+	if err != nil {
+		return err
+	}
+	var failed []error
+	for _, page := range pages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e.lookup(course.ID, pagesDir, page.URL) != nil {
+			continue
+		}
+		// the list leaves out the bodies
+		page, err = e.r.GetPage(ctx, course.ID, page.URL)
+		if err != nil {
+			failed = append(failed, err)
+			continue
+		}
+		e.AddPage(course, page)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d pages could not be fetched, the first: %w", len(failed), len(pages), failed[0])
+	}
+	return nil
+}
+
+// AddPage adds page, fetched with its body, if pages are exported. It is exported with its last update
+// and editor to pages/<URL slug> within the course's directory.
+func (e *Exporter) AddPage(course Course, page Page) {
+	if !e.exports(pagesDir) {
+		return
+	}
+	var meta []metaField
+	if !page.UpdatedAt.IsZero() {
+		meta = append(meta, metaField{"updated_at", "Updated", page.UpdatedAt})
+	}
+	if page.LastEditedBy.DisplayName != "" {
+		meta = append(meta, metaField{"last_edited_by", "Last edited by", page.LastEditedBy.DisplayName})
+	}
+	e.add(&document{
+		course:  course,
+		kind:    pagesDir,
+		id:      page.URL,
+		name:    page.URL,
+		title:   page.Title,
+		htmlURL: page.HTMLURL,
+		meta:    meta,
+		body:    page.Body,
+		formats: e.opts.Pages,
+		data:    page,
+	})
+}
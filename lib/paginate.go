@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// pageSize is the number of items requested per page, Canvas caps this server-side at 100
+const pageSize = 100
+
+// withPageSize returns rawURL with its per_page query parameter set to pageSize
+func withPageSize(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("per_page", strconv.Itoa(pageSize))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// getPaginated requests rawURL and every following page advertised in the response's Link header,
+// appending the decoded elements of each page to the slice pointed to by out
+func (c *Client) getPaginated(ctx context.Context, rawURL string, out interface{}) error {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("getPaginated: out must be a pointer to a slice")
+	}
+	next, err := withPageSize(rawURL)
+	if err != nil {
+		return err
+	}
+	for next != "" {
+		resp, body, err := c.get(ctx, next)
+		if err != nil {
+			return err
+		}
+		page := reflect.New(slice.Elem().Type())
+		err = decode(resp, body, page.Interface())
+		if err != nil {
+			return err
+		}
+		slice.Elem().Set(reflect.AppendSlice(slice.Elem(), page.Elem()))
+		next = nextPage(resp)
+	}
+	return nil
+}
+
+// nextPage returns the URL of the page following resp as given by its RFC 5988 Link header,
+// or an empty string if resp is the last page
+func nextPage(resp *http.Response) string {
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			params := strings.Split(link, ";")
+			target := strings.Trim(strings.TrimSpace(params[0]), "<>")
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || strings.ToLower(kv[0]) != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					if rel != "next" {
+						continue
+					}
+					u, err := resp.Request.URL.Parse(target)
+					if err != nil {
+						return ""
+					}
+					return u.String()
+				}
+			}
+		}
+	}
+	return ""
+}
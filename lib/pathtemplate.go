@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultOutput is the directory files are downloaded to when none is configured
+const DefaultOutput = "out"
+
+// layoutTemplates are the path templates behind each Layout, relative to the output directory
+var layoutTemplates = map[Layout]string{
+	LayoutFlat:    `{{compact .Course.Name}}/{{compact .Filename}}`,
+	LayoutFolders: `{{compact .Course.Name}}/{{compact .FolderPath}}/{{compact .Filename}}`,
+	LayoutModules: `{{compact .Course.Name}}/{{if .Module.ID}}{{printf "%02d" .Module.Position}}-{{compact .Module.Name}}{{end}}/{{compact .Filename}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	// compact removes every space, as paths have always been written
+	"compact": func(s string) string { return strings.ReplaceAll(s, " ", "") },
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+}
+
+// PathData is what a path template is executed against. The file's own fields, such as .DisplayName,
+// .Filename and .ID, are available directly alongside the course, module and module item it was found through.
+type PathData struct {
+	File
+	Course Course
+	// Module is the zero Module for files found outside of any module
+	Module Module
+	// Folder is the module item the file was found through, the zero Folder if none
+	Folder Folder
+	// Term is the name of the course's enrollment term
+	Term string
+	// CourseCode is the course's code, e.g. LC-AI
+	CourseCode string
+	// Position is the position of the module item within its module
+	Position int
+
+	folderPath func() (string, error)
+}
+
+// FolderPath returns the path of the file's folder below the root of the course's files area
+func (d PathData) FolderPath() (string, error) {
+	if d.folderPath == nil {
+		return "", nil
+	}
+	return d.folderPath()
+}
+
+// String returns the course's name so templates can print {{.Course}} directly
+func (course Course) String() string {
+	return course.Name
+}
+
+// String returns the module's name so templates can print {{.Module}} directly
+func (module Module) String() string {
+	return module.Name
+}
+
+// String returns the module item's title so templates can print {{.Folder}} directly
+func (folder Folder) String() string {
+	return folder.Title
+}
+
+// PathTemplate builds the local path of every downloaded file. Every element produced by the template
+// is sanitised, so paths are valid on every platform and never escape the root directory.
+type PathTemplate struct {
+	root string
+	tmpl *template.Template
+}
+
+// NewPathTemplate parses output, which is either a directory to lay files out in according to layout,
+// or a template for the full path of each file such as out/{{.Term}}/{{.CourseCode}}/{{.Filename}}
+func NewPathTemplate(output string, layout Layout) (*PathTemplate, error) {
+	if output == "" {
+		output = DefaultOutput
+	}
+	output = filepath.ToSlash(output)
+	root, text := output, layoutTemplates[layout]
+	if i := strings.Index(output, "{{"); i >= 0 {
+		// the root is the directory up to the first templated path element
+		root, text = ".", output
+		if j := strings.LastIndex(output[:i], "/"); j >= 0 {
+			root, text = output[:j+1], output[j+1:]
+		}
+	} else if text == "" {
+		return nil, fmt.Errorf("unknown layout %q", layout)
+	}
+	tmpl, err := template.New("output").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing output template: %w", err)
+	}
+	// catch unknown fields and the like now, rather than once for every file
+	sample := newPathData(FileSource{
+		Course: Course{ID: 1, Name: "Course", CourseCode: "C1"},
+		Module: Module{ID: 1, Name: "Module", Position: 1},
+		Item:   Folder{Title: "Item", Position: 1},
+	}, File{ID: 1, Filename: "file.pdf", DisplayName: "file.pdf"}, func() (string, error) { return "folder", nil })
+	if err := tmpl.Execute(ioutil.Discard, sample); err != nil {
+		return nil, fmt.Errorf("output template: %w", err)
+	}
+	return &PathTemplate{root: filepath.Clean(filepath.FromSlash(root)), tmpl: tmpl}, nil
+}
+
+// Root returns the directory every path produced by the template lies within
+func (t *PathTemplate) Root() string {
+	return t.root
+}
+
+// Path executes the template against data
+func (t *PathTemplate) Path(data PathData) (string, error) {
+	var buf bytes.Buffer
+	err := t.tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	rel := SanitisePath(buf.String())
+	if rel == "" {
+		return "", fmt.Errorf("output template produced an empty path for %s", data.Filename)
+	}
+	return filepath.Join(t.root, filepath.FromSlash(rel)), nil
+}
+
+// courseDir returns the directory the template places every file of course within, the deepest
+// directory the paths of any two of its files share
+func (t *PathTemplate) courseDir(course Course) (string, error) {
+	noFolder := func() (string, error) { return "", nil }
+	var elems [2][]string
+	for i, name := range []string{"a", "b"} {
+		p, err := t.Path(newPathData(FileSource{Course: course}, File{ID: i + 1, Filename: name, DisplayName: name}, noFolder))
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(t.root, p)
+		if err != nil {
+			return "", err
+		}
+		elems[i] = strings.Split(filepath.ToSlash(rel), "/")
+	}
+	n := 0
+	for n < len(elems[0])-1 && n < len(elems[1])-1 && elems[0][n] == elems[1][n] {
+		n++
+	}
+	return filepath.Join(append([]string{t.root}, elems[0][:n]...)...), nil
+}
+
+// newPathData describes file found through source for a path template, with every name sanitised
+// so none of them can introduce extra path elements
+func newPathData(source FileSource, file File, folderPath func() (string, error)) PathData {
+	term := source.Course.Term.Name
+	if term == "" {
+		term = strconv.Itoa(source.Course.EnrollmentTermID)
+	}
+	file.Filename = SanitiseName(file.Filename)
+	file.DisplayName = SanitiseName(file.DisplayName)
+	source.Course.Name = SanitiseName(source.Course.Name)
+	source.Module.Name = SanitiseName(source.Module.Name)
+	source.Item.Title = SanitiseName(source.Item.Title)
+	return PathData{
+		File:       file,
+		Course:     source.Course,
+		Module:     source.Module,
+		Folder:     source.Item,
+		Term:       SanitiseName(term),
+		CourseCode: SanitiseName(source.Course.CourseCode),
+		Position:   source.Item.Position,
+		folderPath: func() (string, error) {
+			p, err := folderPath()
+			return SanitisePath(p), err
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewPathTemplate(t *testing.T) {
+	tests := []struct {
+		output string
+		layout Layout
+		root   string
+		path   string
+		err    bool
+	}{
+		{"", LayoutFlat, "out", "out/IntrotoAI/Notes.pdf", false},
+		{"dl", LayoutModules, "dl", "dl/IntrotoAI/02-Week1/Notes.pdf", false},
+		{"out/{{.Term}}/{{.CourseCode}}/{{lower .Filename}}", LayoutFlat, "out", "out/2026/AI-101/notes.pdf", false},
+		{"{{.Course}}/{{.Module}}/{{.ID}}-{{.Filename}}", LayoutFlat, ".", "Intro to AI/Week 1/7-Notes.pdf", false},
+		{"out/{{.Nope}}", LayoutFlat, "", "", true},
+		{"out/{{.Course.Nope}}/{{.Filename}}", LayoutFlat, "", "", true},
+		{"out/{{nope .Filename}}", LayoutFlat, "", "", true},
+		{"out/{{.Filename", LayoutFlat, "", "", true},
+		{"out", Layout("tree"), "", "", true},
+	}
+	source := FileSource{
+		Course: Course{ID: 1, Name: "Intro to AI", CourseCode: "AI-101"},
+		Module: Module{ID: 3, Name: "Week 1", Position: 2},
+	}
+	source.Course.Term.Name = "2026"
+	file := File{ID: 7, Filename: "Notes.pdf", DisplayName: "Notes.pdf"}
+	for _, tt := range tests {
+		tmpl, err := NewPathTemplate(tt.output, tt.layout)
+		if (err != nil) != tt.err {
+			t.Errorf("NewPathTemplate(%q, %s) error = %v, want error %v", tt.output, tt.layout, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tmpl.Root() != filepath.FromSlash(tt.root) {
+			t.Errorf("NewPathTemplate(%q).Root() = %q, want %q", tt.output, tmpl.Root(), tt.root)
+		}
+		path, err := tmpl.Path(newPathData(source, file, func() (string, error) { return "", nil }))
+		if err != nil || path != filepath.FromSlash(tt.path) {
+			t.Errorf("%q: Path() = %q, %v, want %q", tt.output, path, err, tt.path)
+		}
+	}
+}
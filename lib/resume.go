@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partMeta is stored alongside a partially downloaded file and identifies the remote version
+// its bytes came from, so the download is only resumed if that version is still current
+type partMeta struct {
+	ID        int       `json:"id"`
+	Size      int       `json:"size"`
+	ETag      string    `json:"etag,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// partPaths returns where the partial download of file to dest and its metadata are kept
+func partPaths(dest string, file File) (string, string) {
+	part := filepath.Join(filepath.Dir(dest), fmt.Sprintf(".%s.%d.part", filepath.Base(dest), file.ID))
+	return part, part + ".json"
+}
+
+// resumeOffset returns how many bytes of file have already been downloaded to part, and the ETag they
+// were served with. A partial download left by a different version of the file is discarded.
+func resumeOffset(file File, part, metaPath string) (int64, string) {
+	info, err := os.Stat(part)
+	if err != nil {
+		return 0, ""
+	}
+	var meta partMeta
+	dat, err := ioutil.ReadFile(metaPath)
+	if err == nil {
+		err = json.Unmarshal(dat, &meta)
+	}
+	if err != nil || meta.ID != file.ID || meta.Size != file.Size || !meta.UpdatedAt.Equal(file.UpdatedAt) ||
+		info.Size() >= int64(file.Size) {
+		removePart(part, metaPath)
+		return 0, ""
+	}
+	return info.Size(), meta.ETag
+}
+
+// writePartMeta records which version of file is being downloaded to the part file
+func writePartMeta(file File, etag, metaPath string) error {
+	dat, err := json.Marshal(partMeta{ID: file.ID, Size: file.Size, ETag: etag, UpdatedAt: file.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath, dat, 0644)
+}
+
+func removePart(part, metaPath string) {
+	_ = os.Remove(part)
+	_ = os.Remove(metaPath)
+}
+
+// resumed reports whether resp continues a download from offset, rather than starting over
+func resumed(resp *http.Response, offset int64) bool {
+	if offset == 0 || resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	// Content-Range: bytes <start>-<end>/<size>
+	spec := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	start, err := strconv.ParseInt(strings.SplitN(spec, "-", 2)[0], 10, 64)
+	return err == nil && start == offset
+}
+
+// rangeHeader returns the Range header requesting everything from offset onwards
+func rangeHeader(offset int64) string {
+	return fmt.Sprintf("bytes=%d-", offset)
+}
@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRetries is the number of times a failed request is retried before giving up
+	maxRetries = 5
+	// baseBackoff and maxBackoff bound the exponential delay between retries
+	baseBackoff = 500 * time.Millisecond
+	maxBackoff  = 30 * time.Second
+	// lowRateLimit is the X-Rate-Limit-Remaining value below which requests are paced
+	lowRateLimit = 200.0
+	// rateLimitLeak is the number of rate-limit units Canvas restores per second
+	rateLimitLeak = 10.0
+	// maxPace is the longest a request is held back for pacing
+	maxPace = 10 * time.Second
+)
+
+// retryTransport is an http.RoundTripper which retries throttled, failed and 5xx requests with jittered
+// exponential backoff, honours Retry-After, and paces every request once Canvas reports the
+// remaining rate-limit budget getting low. A single retryTransport is shared by the whole crawl.
+type retryTransport struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	remaining float64
+	cost      float64
+	known     bool
+}
+
+func newRetryTransport(next http.RoundTripper) *retryTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &retryTransport{next: next}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// requests with a body can only be resent if it can be recreated
+	replayable := req.Body == nil || req.GetBody != nil
+	for attempt := 0; ; attempt++ {
+		if err := sleep(req, t.pace()); err != nil {
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.observe(resp)
+		}
+		if !replayable || attempt >= maxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		retry, wait := shouldRetry(resp, err)
+		if !retry {
+			return resp, err
+		}
+		if wait <= 0 {
+			wait = backoff(attempt)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err := sleep(req, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// observe records the rate-limit budget and request cost Canvas reports in resp
+func (t *retryTransport) observe(resp *http.Response) {
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-Rate-Limit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	cost, _ := strconv.ParseFloat(resp.Header.Get("X-Request-Cost"), 64)
+	t.mu.Lock()
+	t.remaining, t.cost, t.known = remaining, cost, true
+	t.mu.Unlock()
+}
+
+// pace returns how long to hold back the next request so the rate-limit bucket can drain
+func (t *retryTransport) pace() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.known || t.remaining >= lowRateLimit {
+		return 0
+	}
+	d := time.Duration((lowRateLimit - t.remaining + t.cost) / rateLimitLeak * float64(time.Second))
+	if d > maxPace {
+		d = maxPace
+	}
+	return d
+}
+
+// shouldRetry reports whether a request which produced resp and err should be retried,
+// and how long the server asked us to wait before doing so, if at all
+func shouldRetry(resp *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		return true, 0
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, retryAfter(resp)
+	case resp.StatusCode == http.StatusForbidden && throttled(resp):
+		return true, retryAfter(resp)
+	}
+	return false, 0
+}
+
+// throttled reports whether a 403 response is Canvas' "Rate Limit Exceeded" rather than a genuine
+// permission error. The body is restored so the caller can still read it.
+func throttled(resp *http.Response) bool {
+	if strings.Contains(strings.ToLower(resp.Status), "rate limit exceeded") {
+		return true
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return err == nil && bytes.Contains(bytes.ToLower(body), []byte("rate limit exceeded"))
+}
+
+// retryAfter parses resp's Retry-After header, given either in seconds or as an HTTP date
+func retryAfter(resp *http.Response) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// backoff returns a randomised delay for the given retry attempt, growing exponentially up to maxBackoff
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// sleep waits for d, returning early with an error if req is cancelled
+func sleep(req *http.Request, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxNameLength is the longest file name, in bytes, accepted by the filesystems we build for
+const maxNameLength = 255
+
+// reservedNames cannot be used as file names on Windows, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitiseName turns name into a single path element that is valid on Linux, macOS and Windows.
+// Separators and characters reserved on any of them are replaced with underscores, the result is NFC
+// normalised and the same name always produces the same result. An empty name is returned as is.
+func SanitiseName(name string) string {
+	if name == "" {
+		return ""
+	}
+	name = norm.NFC.String(name)
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, r == 0x7f, r == utf8.RuneError:
+			return '_'
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	// Windows silently drops trailing dots and spaces
+	name = strings.TrimLeft(strings.TrimRight(name, ". "), " ")
+	if name == "" {
+		return "_"
+	}
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	if reservedNames[strings.TrimSpace(base)] {
+		name = "_" + name
+	}
+	return truncateName(name)
+}
+
+// SanitisePath sanitises every element of the slash separated path p, so it can neither escape
+// the directory it is joined to nor contain invalid names
+func SanitisePath(p string) string {
+	elems := strings.Split(p, "/")
+	clean := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		if elem == "" || elem == "." {
+			continue
+		}
+		clean = append(clean, SanitiseName(elem))
+	}
+	return strings.Join(clean, "/")
+}
+
+// truncateName shortens name to maxNameLength bytes, keeping its extension and whole characters
+func truncateName(name string) string {
+	if len(name) <= maxNameLength {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	base := name[:maxNameLength-len(ext)]
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return base + ext
+}
+
+// withID inserts a file ID before p's extension, turning notes.pdf into notes-1234.pdf
+func withID(p string, id int) string {
+	ext := filepath.Ext(p)
+	if ext == filepath.Base(p) {
+		ext = ""
+	}
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(p, ext), id, ext)
+}
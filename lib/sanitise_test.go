@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitiseName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"notes.pdf", "notes.pdf"},
+		{"Week 1: Intro/Overview?.pdf", "Week 1_ Intro_Overview_.pdf"},
+		{`a\b*c"d<e>f|g`, "a_b_c_d_e_f_g"},
+		{"tab\there", "tab_here"},
+		{"trailing. . ", "trailing"},
+		{"  leading", "leading"},
+		{"...", "_"},
+		{"CON", "_CON"},
+		{"con.txt", "_con.txt"},
+		{"LPT1 .txt", "_LPT1 .txt"},
+		{"CONSOLE.txt", "CONSOLE.txt"},
+		// a decomposed é is composed, as macOS would otherwise store a different name
+		{"re\u0301sume\u0301.pdf", "r\u00e9sum\u00e9.pdf"},
+	}
+	for _, tt := range tests {
+		if got := SanitiseName(tt.in); got != tt.want {
+			t.Errorf("SanitiseName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitiseNameTruncates(t *testing.T) {
+	long := strings.Repeat("é", 200) + ".pdf"
+	got := SanitiseName(long)
+	if len(got) > maxNameLength {
+		t.Errorf("SanitiseName left %d bytes, want at most %d", len(got), maxNameLength)
+	}
+	if !strings.HasSuffix(got, "é.pdf") {
+		t.Errorf("SanitiseName(%q...) = %q, want it to keep whole characters and the extension", long[:10], got)
+	}
+}
+
+func TestSanitisePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Intro/Week1/notes.pdf", "Intro/Week1/notes.pdf"},
+		{"../../etc/passwd", "_/_/etc/passwd"},
+		{"/abs//./a:b", "abs/a_b"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := SanitisePath(tt.in); got != tt.want {
+			t.Errorf("SanitisePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWithID(t *testing.T) {
+	tests := []struct {
+		in   string
+		id   int
+		want string
+	}{
+		{"notes.pdf", 1234, "notes-1234.pdf"},
+		{"out/Intro/notes.tar.gz", 7, "out/Intro/notes.tar-7.gz"},
+		{"README", 7, "README-7"},
+		{".hidden", 7, ".hidden-7"},
+	}
+	for _, tt := range tests {
+		if got := withID(tt.in, tt.id); got != tt.want {
+			t.Errorf("withID(%q, %d) = %q, want %q", tt.in, tt.id, got, tt.want)
+		}
+	}
+}
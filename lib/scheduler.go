@@ -0,0 +1,302 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJobs is the number of concurrent downloads used when none is configured
+const DefaultJobs = 4
+
+// SyncMode selects how a Scheduler decides whether a file needs downloading
+type SyncMode int
+
+const (
+	// SyncMissing downloads files which are not on disk yet
+	SyncMissing SyncMode = iota
+	// SyncChanged also re-downloads files whose Canvas metadata changed since they were last downloaded
+	SyncChanged
+	// SyncAll downloads every file regardless of what is on disk
+	SyncAll
+)
+
+// SchedulerOptions configures a Scheduler
+type SchedulerOptions struct {
+	// Jobs is the number of concurrent downloads, DefaultJobs if not positive
+	Jobs int
+	Mode SyncMode
+	// Manifest records downloaded files between runs, it is saved by Wait
+	Manifest *Manifest
+	// Output builds the path of every file, files are laid out flat in DefaultOutput if nil
+	Output *PathTemplate
+	// Filter selects which files are downloaded
+	Filter Filter
+	// Progress is told about every download, if not nil
+	Progress Progress
+}
+
+// Progress is told about the downloads of a Scheduler as they happen, e.g. to display them.
+// Its methods are called concurrently from every worker.
+type Progress interface {
+	// Queued is called when file is queued for download
+	Queued(file File)
+	// Started is called when a worker starts downloading file, it is not called for skipped files
+	Started(file File)
+	// Transferred is called as each further n bytes of file are written to disk
+	Transferred(file File, n int64)
+	// Finished is called with the result of every queued file, whether or not it was downloaded
+	Finished(result DownloadResult)
+}
+
+// DownloadResult describes the outcome of a single scheduled download
+type DownloadResult struct {
+	Course  Course
+	File    File
+	Path    string
+	Bytes   int64
+	Skipped bool
+	Err     error
+}
+
+// DownloadSummary aggregates the results of every download run by a Scheduler
+type DownloadSummary struct {
+	Downloaded int
+	Skipped    int
+	Failed     int
+	// Cancelled counts files which were not downloaded, or not finished, because the run was cancelled
+	Cancelled int
+	// Excluded counts files left out by the ignore rules or the filter
+	Excluded int
+	Bytes    int64
+	Errors   []error
+}
+
+// FileSource describes where a file was found
+type FileSource struct {
+	Course Course
+	// Module is the zero Module for files found outside of any module
+	Module Module
+	// Item is the module item which referred to the file, the zero Folder if none
+	Item Folder
+}
+
+type downloadJob struct {
+	source FileSource
+	file   File
+	path   string
+}
+
+// Scheduler downloads files through a bounded pool of workers, fetching each Canvas file at most once
+// no matter how many courses, modules or pages it is discovered through
+type Scheduler struct {
+	ctx      context.Context
+	r        Requester
+	mode     SyncMode
+	manifest *Manifest
+	output   *PathTemplate
+	filter   Filter
+	progress Progress
+	folders  folderTree
+	// claims maps each lower-cased local path to the ID of the file written there, so files with
+	// the same name on case-insensitive filesystems are detected too
+	claims   map[string]int
+	claimsMu sync.Mutex
+	queue    chan downloadJob
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	seen     map[int]bool
+	summary  DownloadSummary
+}
+
+// NewScheduler starts a Scheduler downloading files as configured by opts. Once ctx is cancelled,
+// downloads in progress are aborted and no further files are started.
+func NewScheduler(ctx context.Context, r Requester, opts SchedulerOptions) *Scheduler {
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = DefaultJobs
+	}
+	manifest := opts.Manifest
+	if manifest == nil {
+		manifest = NewManifest()
+	}
+	output := opts.Output
+	if output == nil {
+		output, _ = NewPathTemplate(DefaultOutput, LayoutFlat)
+	}
+	s := &Scheduler{
+		ctx:      ctx,
+		r:        r,
+		mode:     opts.Mode,
+		manifest: manifest,
+		output:   output,
+		filter:   opts.Filter,
+		progress: opts.Progress,
+		queue:    make(chan downloadJob, jobs),
+		seen:     make(map[int]bool),
+		claims:   make(map[string]int),
+	}
+	for _, entry := range manifest.Entries() {
+		s.claims[strings.ToLower(entry.Path)] = entry.ID
+	}
+	s.wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Add queues file, found through source, for download unless it is ignored, filtered out or has already
+// been queued, blocking while every worker is busy. Local paths are assigned in the order files are added.
+func (s *Scheduler) Add(source FileSource, file File) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.mu.Lock()
+	if file.ID != 0 && s.seen[file.ID] {
+		s.mu.Unlock()
+		return
+	}
+	s.seen[file.ID] = true
+	if ignored := s.r.Ignore.Ignored(source, file); ignored || !s.filter.Match(file) {
+		s.summary.Excluded++
+		s.mu.Unlock()
+		reason := "filter"
+		if ignored {
+			reason = "ignore rules"
+		}
+		s.r.Log.Debug("Excluded", "course", source.Course.Name, "file_id", file.ID, "name", file.DisplayName, "by", reason)
+		return
+	}
+	s.mu.Unlock()
+	job := downloadJob{source: source, file: file}
+	var err error
+	job.path, err = s.localPath(job)
+	if err != nil {
+		s.collect(DownloadResult{Course: source.Course, File: file, Path: file.Filename, Err: err})
+		return
+	}
+	if s.progress != nil {
+		s.progress.Queued(file)
+	}
+	s.queue <- job
+}
+
+// Wait stops accepting new files, waits for every queued download to finish, saves the manifest
+// and returns the aggregated results
+func (s *Scheduler) Wait() DownloadSummary {
+	close(s.queue)
+	s.wg.Wait()
+	if err := s.manifest.Save(); err != nil {
+		s.summary.Errors = append(s.summary.Errors, fmt.Errorf("saving manifest: %w", err))
+	}
+	return s.summary
+}
+
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+	for job := range s.queue {
+		result := s.download(job)
+		s.collect(result)
+		if s.progress != nil {
+			s.progress.Finished(result)
+		}
+	}
+}
+
+func (s *Scheduler) download(job downloadJob) DownloadResult {
+	result := DownloadResult{Course: job.source.Course, File: job.file, Path: job.path}
+	if err := s.ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+	_, err := os.Stat(result.Path)
+	exists := !os.IsNotExist(err)
+	switch {
+	case s.mode == SyncChanged && exists && !s.manifest.Changed(job.file):
+		result.Skipped = true
+	case s.mode == SyncMissing && exists:
+		// assume files downloaded before the manifest was kept are up to date
+		if _, ok := s.manifest.Lookup(job.file.ID); !ok {
+			result.Err = s.record(job, result.Path)
+		}
+		result.Skipped = true
+	}
+	if result.Skipped {
+		s.r.Log.Debug("Up to date", "course", job.source.Course.Name, "file_id", job.file.ID, "path", result.Path)
+		return result
+	}
+	start := time.Now()
+	var progress func(int64)
+	if s.progress != nil {
+		s.progress.Started(job.file)
+		progress = func(n int64) { s.progress.Transferred(job.file, n) }
+	}
+	result.Bytes, result.Err = job.file.download(s.ctx, result.Path, s.r, progress)
+	if result.Err == nil {
+		result.Err = s.record(job, result.Path)
+	}
+	fields := []interface{}{"course", job.source.Course.Name, "module", job.source.Module.Name, "file_id", job.file.ID,
+		"path", result.Path, "bytes", result.Bytes, "duration", time.Since(start)}
+	if result.Err != nil {
+		s.r.Log.Debug("Download failed", append(fields, "error", result.Err)...)
+	} else {
+		s.r.Log.Info("Downloaded", fields...)
+	}
+	return result
+}
+
+// localPath returns the path job's file is downloaded to. If another file already claimed the path
+// the template produces, the file's ID is added to its name. The path a file was given is kept in
+// the manifest, so every run resolves collisions the same way.
+func (s *Scheduler) localPath(job downloadJob) (string, error) {
+	course := job.source.Course
+	folderPath := func() (string, error) {
+		return s.folders.path(s.ctx, s.r, course, job.file.FolderID)
+	}
+	p, err := s.output.Path(newPathData(job.source, job.file, folderPath))
+	if err != nil {
+		return "", err
+	}
+	suffixed := withID(p, job.file.ID)
+	s.claimsMu.Lock()
+	defer s.claimsMu.Unlock()
+	if entry, ok := s.manifest.Lookup(job.file.ID); ok && (entry.Path == p || entry.Path == suffixed) {
+		p = entry.Path
+	} else if owner, ok := s.claims[strings.ToLower(p)]; ok && owner != job.file.ID {
+		p = suffixed
+	}
+	s.claims[strings.ToLower(p)] = job.file.ID
+	return p, nil
+}
+
+// record adds job, downloaded to path, to the manifest
+func (s *Scheduler) record(job downloadJob, path string) error {
+	entry, err := newManifestEntry(job.source.Course, job.source.Module, job.file, path)
+	if err != nil {
+		return err
+	}
+	s.manifest.Record(entry)
+	return nil
+}
+
+// collect adds result to the summary
+func (s *Scheduler) collect(result DownloadResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case result.Err != nil && s.ctx.Err() != nil:
+		s.summary.Cancelled++
+	case result.Err != nil:
+		s.summary.Failed++
+		s.summary.Errors = append(s.summary.Errors, fmt.Errorf("%s: %w", result.Path, result.Err))
+	case result.Skipped:
+		s.summary.Skipped++
+	default:
+		s.summary.Downloaded++
+		s.summary.Bytes += result.Bytes
+	}
+}
@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fileServer serves "content of <id>" at /files/<id>, counting the requests for each file and how
+// many were in flight at once. IDs of 404 and above are not found.
+type fileServer struct {
+	delay time.Duration
+
+	mu          sync.Mutex
+	requests    map[string]int
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+	time.Sleep(s.delay)
+	var id int
+	fmt.Sscanf(r.URL.Path, "/files/%d", &id)
+	if id >= 404 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	fmt.Fprintf(w, "content of %d", id)
+}
+
+// requestCount returns how many times the file with the given ID was requested
+func (s *fileServer) requestCount(id int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[fmt.Sprintf("/files/%d", id)]
+}
+
+func newFileServer(t *testing.T, delay time.Duration) (*fileServer, *httptest.Server) {
+	fs := &fileServer{delay: delay, requests: make(map[string]int)}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+// testFile describes the file with the given ID and name as srv serves it
+func testFile(srv *httptest.Server, id int, name string) File {
+	return File{
+		ID:          id,
+		Filename:    name,
+		DisplayName: name,
+		URL:         fmt.Sprintf("%s/files/%d", srv.URL, id),
+		ContentType: "application/pdf",
+		Size:        len(fmt.Sprintf("content of %d", id)),
+		UpdatedAt:   time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// runScheduler downloads files from course to root with a fresh Scheduler and the manifest kept in
+// root, as a run of download does
+func runScheduler(t *testing.T, srv *httptest.Server, root string, opts SchedulerOptions, files ...File) DownloadSummary {
+	t.Helper()
+	manifest, err := LoadManifest(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Manifest = manifest
+	opts.Output, err = NewPathTemplate(root, LayoutFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(context.Background(), testRequester(srv), opts)
+	for _, file := range files {
+		s.Add(FileSource{Course: Course{ID: 1, Name: "Intro"}}, file)
+	}
+	return s.Wait()
+}
+
+func TestSchedulerSummary(t *testing.T) {
+	fs, srv := newFileServer(t, 0)
+	root := t.TempDir()
+	files := []File{
+		testFile(srv, 1, "notes.pdf"),
+		testFile(srv, 2, "slides.pdf"),
+		// found again through another module
+		testFile(srv, 1, "notes.pdf"),
+		testFile(srv, 404, "gone.pdf"),
+		testFile(srv, 3, "huge.pdf"),
+	}
+	files[4].Size = 1 << 20
+	summary := runScheduler(t, srv, root, SchedulerOptions{Filter: Filter{MaxSize: 1 << 10}}, files...)
+
+	if summary.Downloaded != 2 || summary.Failed != 1 || summary.Excluded != 1 || summary.Skipped != 0 {
+		t.Errorf("summary = %+v, want 2 downloaded, 1 failed and 1 excluded", summary)
+	}
+	if want := int64(len("content of 1") + len("content of 2")); summary.Bytes != want {
+		t.Errorf("Bytes = %d, want %d", summary.Bytes, want)
+	}
+	if len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0].Error(), "gone.pdf") {
+		t.Errorf("Errors = %v, want the failure of gone.pdf", summary.Errors)
+	}
+	if n := fs.requestCount(1); n != 1 {
+		t.Errorf("file 1 was requested %d times, want once", n)
+	}
+	if n := fs.requestCount(3); n != 0 {
+		t.Errorf("the excluded file was requested %d times", n)
+	}
+	if got := readFile(t, filepath.Join(root, "Intro", "slides.pdf")); got != "content of 2" {
+		t.Errorf("slides.pdf = %q, want %q", got, "content of 2")
+	}
+}
+
+func TestSchedulerJobs(t *testing.T) {
+	fs, srv := newFileServer(t, 50*time.Millisecond)
+	var files []File
+	for id := 1; id <= 6; id++ {
+		files = append(files, testFile(srv, id, fmt.Sprintf("%d.pdf", id)))
+	}
+	summary := runScheduler(t, srv, t.TempDir(), SchedulerOptions{Jobs: 2}, files...)
+	if summary.Downloaded != 6 {
+		t.Errorf("downloaded %d files, want 6", summary.Downloaded)
+	}
+	if fs.maxInFlight != 2 {
+		t.Errorf("%d downloads ran at once, want 2", fs.maxInFlight)
+	}
+}
+
+func TestSchedulerSyncModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     SyncMode
+		changed  bool
+		recorded bool
+		want     int
+	}{
+		{"missing, unchanged", SyncMissing, false, true, 0},
+		{"missing, changed", SyncMissing, true, true, 0},
+		{"missing, not in manifest", SyncMissing, false, false, 0},
+		{"changed, unchanged", SyncChanged, false, true, 0},
+		{"changed, changed", SyncChanged, true, true, 1},
+		{"changed, not in manifest", SyncChanged, false, false, 1},
+		{"all", SyncAll, false, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, srv := newFileServer(t, 0)
+			root := t.TempDir()
+			file := testFile(srv, 1, "notes.pdf")
+			runScheduler(t, srv, root, SchedulerOptions{}, file)
+			if !tt.recorded {
+				// as if downloaded before the manifest was kept
+				if err := os.Remove(filepath.Join(root, manifestFile)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.changed {
+				file.UpdatedAt = file.UpdatedAt.Add(time.Hour)
+			}
+
+			summary := runScheduler(t, srv, root, SchedulerOptions{Mode: tt.mode}, file)
+			if got := fs.requestCount(1) - 1; got != tt.want {
+				t.Errorf("downloaded again %d times, want %d", got, tt.want)
+			}
+			if summary.Skipped != 1-tt.want || summary.Downloaded != tt.want {
+				t.Errorf("summary = %+v, want %d skipped", summary, 1-tt.want)
+			}
+			manifest, err := LoadManifest(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := manifest.Lookup(1); !ok {
+				t.Error("the file is missing from the manifest")
+			}
+		})
+	}
+}
+
+func TestSchedulerCollisionsStable(t *testing.T) {
+	_, srv := newFileServer(t, 0)
+	root := t.TempDir()
+	notes, other := testFile(srv, 1, "notes.pdf"), testFile(srv, 2, "Notes.pdf")
+	want := map[int]string{
+		1: filepath.Join(root, "Intro", "notes.pdf"),
+		// the names only differ in case, which clashes on case-insensitive filesystems
+		2: filepath.Join(root, "Intro", "Notes-2.pdf"),
+	}
+	for run, files := range [][]File{{notes, other}, {other, notes}} {
+		summary := runScheduler(t, srv, root, SchedulerOptions{Mode: SyncAll}, files...)
+		if summary.Downloaded != 2 {
+			t.Fatalf("run %d: summary = %+v, want 2 downloaded", run+1, summary)
+		}
+		manifest, err := LoadManifest(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id, path := range want {
+			entry, _ := manifest.Lookup(id)
+			if entry.Path != path {
+				t.Errorf("run %d: file %d was written to %s, want %s", run+1, id, entry.Path, path)
+			}
+			if got, want := readFile(t, path), fmt.Sprintf("content of %d", id); got != want {
+				t.Errorf("run %d: %s = %q, want %q", run+1, path, got, want)
+			}
+		}
+	}
+}
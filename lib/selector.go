@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CourseSelector matches courses against a single selector given on the command line
+type CourseSelector func(course Course) bool
+
+// CourseSelection picks courses matching any of its selectors, or every course if it has none.
+// With CurrentTerm set only courses running at the time are picked.
+type CourseSelection struct {
+	Selectors   []CourseSelector
+	CurrentTerm bool
+}
+
+// ParseCourseSelection parses one selector from each spec:
+//
+//	id:<id>               the course with this ID
+//	code:<code>           courses with this course code, ignoring case
+//	term:<id|name>        courses in this enrollment term, by ID or name ignoring case
+//	state:<state>         courses in this workflow state, e.g. available or completed
+//	enrollment:<type>     courses you are enrolled in as this type, e.g. student, teacher or ta
+//	name:<regexp>         courses whose name matches the regular expression
+//	<name>                courses with this name, ignoring case and spaces
+//
+// A course is picked if it matches any of the selectors.
+func ParseCourseSelection(specs []string, currentTerm bool) (CourseSelection, error) {
+	selection := CourseSelection{CurrentTerm: currentTerm}
+	for _, spec := range specs {
+		selector, err := parseCourseSelector(spec)
+		if err != nil {
+			return CourseSelection{}, err
+		}
+		selection.Selectors = append(selection.Selectors, selector)
+	}
+	return selection, nil
+}
+
+func parseCourseSelector(spec string) (CourseSelector, error) {
+	name := compactName(spec)
+	kv := strings.SplitN(spec, ":", 2)
+	if len(kv) == 1 {
+		return func(course Course) bool { return compactName(course.Name) == name }, nil
+	}
+	key, value := strings.ToLower(kv[0]), kv[1]
+	switch key {
+	case "id":
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid course ID %q", value)
+		}
+		return func(course Course) bool { return course.ID == id }, nil
+	case "code":
+		return func(course Course) bool { return strings.EqualFold(course.CourseCode, value) }, nil
+	case "term":
+		id, err := strconv.Atoi(value)
+		return func(course Course) bool {
+			return (err == nil && course.EnrollmentTermID == id) || strings.EqualFold(course.Term.Name, value)
+		}, nil
+	case "state":
+		return func(course Course) bool { return strings.EqualFold(course.WorkflowState, value) }, nil
+	case "enrollment":
+		want := enrollmentType(value)
+		return func(course Course) bool {
+			for _, enrollment := range course.Enrollments {
+				if enrollmentType(enrollment.Type) == want {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "name":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid course name pattern: %w", err)
+		}
+		return func(course Course) bool { return re.MatchString(course.Name) }, nil
+	}
+	// not a selector, but a course name containing a colon
+	return func(course Course) bool { return compactName(course.Name) == name }, nil
+}
+
+// compactName lower-cases name and removes its spaces, as course names have always been given on the command line
+func compactName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", ""))
+}
+
+// enrollmentType normalises Canvas' enrollment types, so StudentEnrollment and student are equal
+func enrollmentType(t string) string {
+	return strings.TrimSuffix(strings.ToLower(t), "enrollment")
+}
+
+// Match reports whether course is picked by the selection at time now
+func (s CourseSelection) Match(course Course, now time.Time) bool {
+	if s.CurrentTerm && !course.Current(now) {
+		return false
+	}
+	if len(s.Selectors) == 0 {
+		return true
+	}
+	for _, selector := range s.Selectors {
+		if selector(course) {
+			return true
+		}
+	}
+	return false
+}
+
+// Current reports whether course is running at time now, using the dates of its term, or of the course
+// itself if the term has none. A missing start or end date leaves that end of the term open.
+func (course Course) Current(now time.Time) bool {
+	start, end := course.Term.StartAt, course.Term.EndAt
+	if start.IsZero() && end.IsZero() {
+		start, end = course.StartAt, course.EndAt
+	}
+	return (start.IsZero() || !now.Before(start)) && (end.IsZero() || !now.After(end))
+}